@@ -0,0 +1,120 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Cipher identifies the AEAD construction that is used to encrypt frames. The id is
+// carried in each keyring frame, so the receiver knows how to decrypt it.
+type Cipher byte
+
+const (
+	// AESGCM selects AES-GCM. The length of the key, that can be 16, 24 or 32, defines
+	// if AES-128, AES-192 or AES-256 is used.
+	AESGCM Cipher = iota
+
+	// XChaCha20Poly1305 selects XChaCha20-Poly1305, which is fast on hosts without
+	// AES-NI. It takes a 32-byte key. The 24-byte nonce is large enough to pick
+	// nonces at random.
+	XChaCha20Poly1305
+)
+
+var (
+	// ErrUnknownCipher is returned if a cipher id is not supported.
+	ErrUnknownCipher = errors.New("unknown cipher")
+
+	// ErrCipherMismatch is returned if a frame has been encrypted with a different
+	// cipher than the key, that is referenced by the frame, is meant for.
+	ErrCipherMismatch = errors.New("cipher mismatch")
+
+	// ErrRandomNonceUnsupported is returned if a random nonce is requested for a cipher
+	// whose nonce is too short to be picked at random safely.
+	ErrRandomNonceUnsupported = errors.New("random nonces are unsupported by cipher")
+)
+
+func (c Cipher) String() string {
+	switch c {
+	case AESGCM:
+		return "AES-GCM"
+	case XChaCha20Poly1305:
+		return "XChaCha20-Poly1305"
+	default:
+		return fmt.Sprintf("Cipher(%d)", byte(c))
+	}
+}
+
+// NewAEAD returns the AEAD of the cipher for the given key.
+func (c Cipher) NewAEAD(key []byte) (cipher.AEAD, error) {
+	switch c {
+	case AESGCM:
+		aes, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("new cipher: %w", err)
+		}
+
+		aesGCM, err := cipher.NewGCM(aes)
+		if err != nil {
+			return nil, fmt.Errorf("new gcm: %w", err)
+		}
+		return aesGCM, nil
+	case XChaCha20Poly1305:
+		xChaCha, err := chacha20poly1305.NewX(key)
+		if err != nil {
+			return nil, fmt.Errorf("new xchacha20-poly1305: %w", err)
+		}
+		return xChaCha, nil
+	default:
+		return nil, fmt.Errorf("cipher %d: %w", byte(c), ErrUnknownCipher)
+	}
+}
+
+func (c Cipher) nonceSize() (int, error) {
+	switch c {
+	case AESGCM:
+		return 12, nil
+	case XChaCha20Poly1305:
+		return chacha20poly1305.NonceSizeX, nil
+	default:
+		return 0, fmt.Errorf("cipher %d: %w", byte(c), ErrUnknownCipher)
+	}
+}
+
+// nonceBytes converts the nonce into a byte slice of the aead's nonce size. If the nonce
+// is nil, a random nonce is generated, which is only allowed for 24-byte nonces.
+func nonceBytes(aead cipher.AEAD, nonce *big.Int) ([]byte, error) {
+	size := aead.NonceSize()
+	if nonce == nil {
+		if size < chacha20poly1305.NonceSizeX {
+			return nil, ErrRandomNonceUnsupported
+		}
+		result := make([]byte, size)
+		if _, err := rand.Read(result); err != nil {
+			return nil, fmt.Errorf("random nonce: %w", err)
+		}
+		return result, nil
+	}
+
+	result := nonce.Bytes()
+	return append(make([]byte, size-len(result)), result...), nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt_test
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/posteo/fader/crypt"
+)
+
+var longKey = append(append([]byte{}, key...), otherKey...)
+
+func TestXChaCha20Poly1305(t *testing.T) {
+	t.Run("EncryptAndDecrypt", func(t *testing.T) {
+		buffer := &bytes.Buffer{}
+		encrypter, err := crypt.NewEncrypterWithCipher(buffer, crypt.XChaCha20Poly1305, longKey)
+		require.NoError(t, err)
+		decrypter, err := crypt.NewDecrypterWithCipher(buffer, crypt.XChaCha20Poly1305, longKey)
+		require.NoError(t, err)
+
+		_, err = encrypter.Write(big.NewInt(5), []byte{1, 2, 3})
+		require.NoError(t, err)
+		assert.Equal(t, 2+24+3+16, buffer.Len())
+
+		nonce := big.NewInt(0)
+		plainText := make([]byte, 3)
+		n, err := decrypter.Read(nonce, plainText)
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+		assert.Equal(t, big.NewInt(5), nonce)
+		assert.Equal(t, []byte{1, 2, 3}, plainText)
+	})
+
+	t.Run("RandomNonce", func(t *testing.T) {
+		buffer := &bytes.Buffer{}
+		encrypter, err := crypt.NewEncrypterWithCipher(buffer, crypt.XChaCha20Poly1305, longKey)
+		require.NoError(t, err)
+		decrypter, err := crypt.NewDecrypterWithCipher(buffer, crypt.XChaCha20Poly1305, longKey)
+		require.NoError(t, err)
+
+		_, err = encrypter.Write(nil, []byte{1, 2, 3})
+		require.NoError(t, err)
+
+		plainText := make([]byte, 3)
+		_, err = decrypter.Read(big.NewInt(0), plainText)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{1, 2, 3}, plainText)
+	})
+
+	t.Run("RandomNonceWithAESGCM", func(t *testing.T) {
+		encrypter, err := crypt.NewEncrypter(&bytes.Buffer{}, key)
+		require.NoError(t, err)
+
+		_, err = encrypter.Write(nil, []byte{1, 2, 3})
+		assert.True(t, errors.Is(err, crypt.ErrRandomNonceUnsupported))
+	})
+
+	t.Run("KeyringWithMixedCiphers", func(t *testing.T) {
+		senderKeyring, err := crypt.NewKeyringWithCipher(2, crypt.XChaCha20Poly1305, longKey)
+		require.NoError(t, err)
+		receiverKeyring, err := crypt.NewKeyring(1, key)
+		require.NoError(t, err)
+		require.NoError(t, receiverKeyring.AddWithCipher(2, crypt.XChaCha20Poly1305, longKey))
+
+		buffer := &bytes.Buffer{}
+		_, err = crypt.NewKeyringEncrypter(buffer, senderKeyring).Write(big.NewInt(1), []byte{1, 2, 3})
+		require.NoError(t, err)
+
+		plainText := make([]byte, 3)
+		_, err = crypt.NewKeyringDecrypter(buffer, receiverKeyring).Read(big.NewInt(0), plainText)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{1, 2, 3}, plainText)
+		assert.Equal(t, crypt.XChaCha20Poly1305, receiverKeyring.Stats()[1].Cipher)
+	})
+
+	t.Run("CipherMismatch", func(t *testing.T) {
+		senderKeyring, err := crypt.NewKeyringWithCipher(1, crypt.XChaCha20Poly1305, longKey)
+		require.NoError(t, err)
+		receiverKeyring, err := crypt.NewKeyring(1, longKey)
+		require.NoError(t, err)
+
+		buffer := &bytes.Buffer{}
+		_, err = crypt.NewKeyringEncrypter(buffer, senderKeyring).Write(big.NewInt(1), []byte{1, 2, 3})
+		require.NoError(t, err)
+
+		_, err = crypt.NewKeyringDecrypter(buffer, receiverKeyring).Read(big.NewInt(0), make([]byte, 3))
+		assert.True(t, errors.Is(err, crypt.ErrCipherMismatch))
+	})
+}
@@ -15,7 +15,6 @@
 package crypt
 
 import (
-	"crypto/aes"
 	"crypto/cipher"
 	"encoding/binary"
 	"errors"
@@ -26,27 +25,28 @@ import (
 
 type decrypter struct {
 	parent io.Reader
-	aesGCM cipher.AEAD
+	aead   cipher.AEAD
 }
 
 // ErrInvalidNonce is returned if an invalid nonce if received.
 var ErrInvalidNonce = errors.New("tried to decrypt with a previouly used nonce")
 
-// NewDecrypter returns a new decrypter.
+// NewDecrypter returns a new decrypter that uses AES-GCM.
 func NewDecrypter(parent io.Reader, key []byte) (Reader, error) {
-	aes, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, fmt.Errorf("new cipher: %w", err)
-	}
+	return NewDecrypterWithCipher(parent, AESGCM, key)
+}
 
-	aesGCM, err := cipher.NewGCM(aes)
+// NewDecrypterWithCipher returns a new decrypter that uses the given cipher. It reads
+// frames of NewEncrypterWithCipher, which don't carry a cipher id.
+func NewDecrypterWithCipher(parent io.Reader, c Cipher, key []byte) (Reader, error) {
+	aead, err := c.NewAEAD(key)
 	if err != nil {
-		return nil, fmt.Errorf("new gcm: %w", err)
+		return nil, err
 	}
 
 	return &decrypter{
 		parent: parent,
-		aesGCM: aesGCM,
+		aead:   aead,
 	}, nil
 }
 
@@ -56,7 +56,7 @@ func (d *decrypter) Read(nonce *big.Int, data []byte) (int, error) {
 		return 0, fmt.Errorf("read length: %w", err)
 	}
 
-	nonceBytes := make([]byte, d.aesGCM.NonceSize())
+	nonceBytes := make([]byte, d.aead.NonceSize())
 	if _, err := d.parent.Read(nonceBytes); err != nil {
 		return 0, fmt.Errorf("read nonce: %w", err)
 	}
@@ -68,9 +68,85 @@ func (d *decrypter) Read(nonce *big.Int, data []byte) (int, error) {
 
 	nonce.SetBytes(nonceBytes)
 
-	plainText, err := d.aesGCM.Open(nil, nonceBytes, cipherText, []byte{})
+	plainText, err := d.aead.Open(nil, nonceBytes, cipherText, []byte{})
+	if err != nil {
+		return 0, fmt.Errorf("aead open: %w", err)
+	}
+	if len(plainText) > len(data) {
+		return 0, fmt.Errorf("plain text of size %d: %w", len(plainText), io.ErrShortBuffer)
+	}
+	copy(data, plainText)
+
+	return len(plainText), nil
+}
+
+type keyringDecrypter struct {
+	parent  io.Reader
+	keyring *Keyring
+}
+
+// NewKeyringDecrypter returns a new decrypter that accepts frames which have been
+// encrypted with any key of the given keyring. Frames of NewEncrypter, which end right
+// after the nonce and the cipher text, are decrypted with the AES-GCM key of id 0, so that
+// the frames of nodes, that predate the keyring, can still be read.
+func NewKeyringDecrypter(parent io.Reader, keyring *Keyring) Reader {
+	return &keyringDecrypter{
+		parent:  parent,
+		keyring: keyring,
+	}
+}
+
+func (d *keyringDecrypter) Read(nonce *big.Int, data []byte) (int, error) {
+	length := uint16(0)
+	if err := binary.Read(d.parent, binary.BigEndian, &length); err != nil {
+		return 0, fmt.Errorf("read length: %w", err)
+	}
+
+	// A keyring frame is always longer than a legacy frame of the same length.
+	frame := make([]byte, legacyNonceSize+int(length))
+	if _, err := io.ReadFull(d.parent, frame); err != nil {
+		return 0, fmt.Errorf("read frame: %w", err)
+	}
+	next := [1]byte{}
+	if _, err := io.ReadFull(d.parent, next[:]); errors.Is(err, io.EOF) {
+		return d.open(nonce, 0, AESGCM, frame[:legacyNonceSize], frame[legacyNonceSize:], data)
+	} else if err != nil {
+		return 0, fmt.Errorf("read frame: %w", err)
+	}
+	frame = append(frame, next[0])
+
+	c := Cipher(frame[0])
+	id := binary.BigEndian.Uint32(frame[1:5])
+
+	nonceSize, err := c.nonceSize()
+	if err != nil {
+		return 0, err
+	}
+
+	header := keyringHeaderSize - 2
+	rest := make([]byte, header+nonceSize+int(length)-len(frame))
+	if _, err := io.ReadFull(d.parent, rest); err != nil {
+		return 0, fmt.Errorf("read frame: %w", err)
+	}
+	frame = append(frame, rest...)
+
+	return d.open(nonce, id, c, frame[header:header+nonceSize], frame[header+nonceSize:], data)
+}
+
+func (d *keyringDecrypter) open(nonce *big.Int, id uint32, c Cipher, nonceBytes, cipherText, data []byte) (int, error) {
+	nonce.SetBytes(nonceBytes)
+
+	entry, err := d.keyring.entry(id, c)
 	if err != nil {
-		return 0, fmt.Errorf("aes open: %w", err)
+		return 0, err
+	}
+
+	plainText, err := entry.open(nonceBytes, cipherText)
+	if err != nil {
+		return 0, fmt.Errorf("open: %w", err)
+	}
+	if len(plainText) > len(data) {
+		return 0, fmt.Errorf("plain text of size %d: %w", len(plainText), io.ErrShortBuffer)
 	}
 	copy(data, plainText)
 
@@ -54,3 +54,24 @@ func TestCorrectNonceReading(t *testing.T) {
 	assert.Equal(t, big.NewInt(2222222), nonce)
 	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8}, plainText)
 }
+
+func FuzzDecrypter(f *testing.F) {
+	input, _ := hex.DecodeString("001800000000000000000000000048d484579c9da1845613bcb0b13154268384ffba962cd4d7")
+	f.Add(input)
+	f.Add([]byte{})
+
+	keyring, err := crypt.NewKeyring(0, key)
+	require.NoError(f, err)
+	frame := &bytes.Buffer{}
+	_, err = crypt.NewKeyringEncrypter(frame, keyring).Write(big.NewInt(1), []byte{1, 2, 3})
+	require.NoError(f, err)
+	f.Add(frame.Bytes())
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		decrypter, err := crypt.NewDecrypter(bytes.NewReader(input), key)
+		require.NoError(t, err)
+		_, _ = decrypter.Read(big.NewInt(0), make([]byte, 8))
+
+		_, _ = crypt.NewKeyringDecrypter(bytes.NewReader(input), keyring).Read(big.NewInt(0), make([]byte, 8))
+	})
+}
@@ -15,7 +15,6 @@
 package crypt
 
 import (
-	"crypto/aes"
 	"crypto/cipher"
 	"encoding/binary"
 	"fmt"
@@ -25,32 +24,39 @@ import (
 
 type encrypter struct {
 	parent io.Writer
-	aesGCM cipher.AEAD
+	aead   cipher.AEAD
 }
 
-// NewEncrypter returns a new encrypter.
+// NewEncrypter returns a new encrypter that uses AES-GCM.
 func NewEncrypter(parent io.Writer, key []byte) (Writer, error) {
-	aes, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, fmt.Errorf("new cipher: %w", err)
-	}
+	return NewEncrypterWithCipher(parent, AESGCM, key)
+}
 
-	aesGCM, err := cipher.NewGCM(aes)
+// NewEncrypterWithCipher returns a new encrypter that uses the given cipher. The frames
+// keep the format of NewEncrypter and don't carry a cipher id, so AES-GCM frames can
+// still be read by decrypters that predate the cipher selection. The decrypter must be
+// set up with the same cipher. Frames of the keyring encrypter carry the cipher id.
+func NewEncrypterWithCipher(parent io.Writer, c Cipher, key []byte) (Writer, error) {
+	aead, err := c.NewAEAD(key)
 	if err != nil {
-		return nil, fmt.Errorf("new gcm: %w", err)
+		return nil, err
 	}
 
 	return &encrypter{
 		parent: parent,
-		aesGCM: aesGCM,
+		aead:   aead,
 	}, nil
 }
 
+// Write encrypts the plain text using the given nonce. If the nonce is nil, a random
+// nonce is used, which is only supported by XChaCha20-Poly1305.
 func (e *encrypter) Write(nonce *big.Int, plainText []byte) (int, error) {
-	nonceBytes := nonce.Bytes()
-	nonceBytes = append(make([]byte, e.aesGCM.NonceSize()-len(nonceBytes)), nonceBytes...)
+	nonceBytes, err := nonceBytes(e.aead, nonce)
+	if err != nil {
+		return 0, err
+	}
 
-	cipherText := e.aesGCM.Seal(nil, nonceBytes, plainText, []byte{})
+	cipherText := e.aead.Seal(nil, nonceBytes, plainText, []byte{})
 
 	length := uint16(len(cipherText))
 	if err := binary.Write(e.parent, binary.BigEndian, length); err != nil {
@@ -67,3 +73,45 @@ func (e *encrypter) Write(nonce *big.Int, plainText []byte) (int, error) {
 
 	return len(plainText), nil
 }
+
+type keyringEncrypter struct {
+	parent  io.Writer
+	keyring *Keyring
+}
+
+// NewKeyringEncrypter returns a new encrypter that uses the current key of the given
+// keyring. Each frame carries the ids of the cipher and the key, so the receiver can
+// pick the right one for decryption. The whole frame is written to the parent in a
+// single call.
+func NewKeyringEncrypter(parent io.Writer, keyring *Keyring) Writer {
+	return &keyringEncrypter{
+		parent:  parent,
+		keyring: keyring,
+	}
+}
+
+// Write encrypts the plain text using the given nonce. If the nonce is nil, a random
+// nonce is used, which is only supported by XChaCha20-Poly1305.
+func (e *keyringEncrypter) Write(nonce *big.Int, plainText []byte) (int, error) {
+	id, entry := e.keyring.currentEntry()
+
+	nonceBytes, err := nonceBytes(entry.aead, nonce)
+	if err != nil {
+		return 0, err
+	}
+
+	cipherText := entry.seal(nonceBytes, plainText)
+
+	frame := make([]byte, keyringHeaderSize, keyringHeaderSize+len(nonceBytes)+len(cipherText))
+	binary.BigEndian.PutUint16(frame[0:2], uint16(len(cipherText)))
+	frame[2] = byte(entry.cipher)
+	binary.BigEndian.PutUint32(frame[3:7], id)
+	frame = append(frame, nonceBytes...)
+	frame = append(frame, cipherText...)
+
+	if _, err := e.parent.Write(frame); err != nil {
+		return 0, fmt.Errorf("write parent: %w", err)
+	}
+
+	return len(plainText), nil
+}
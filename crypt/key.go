@@ -0,0 +1,107 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"bytes"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// PassphraseIterations defines the number of PBKDF2 iterations that are used to derive a
+// key from a passphrase.
+const PassphraseIterations = 600000
+
+var (
+	// ErrInvalidKeySize is returned if a key of a size other than 16, 24 or 32 is requested or loaded.
+	ErrInvalidKeySize = errors.New("invalid key size")
+
+	// ErrMissingSalt is returned if a key should be derived without a salt.
+	ErrMissingSalt = errors.New("missing salt")
+
+	// ErrInsecureKeyFile is returned if a key file is accessible by group or others.
+	ErrInsecureKeyFile = errors.New("key file is accessible by group or others")
+)
+
+// DeriveKeyFromPassphrase derives a key of the given size from the passphrase using PBKDF2
+// with SHA-256. The salt should be specific to the group the key is used for.
+func DeriveKeyFromPassphrase(passphrase string, salt []byte, size int) ([]byte, error) {
+	if err := validateKeySize(size); err != nil {
+		return nil, err
+	}
+	if len(salt) == 0 {
+		return nil, ErrMissingSalt
+	}
+
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, PassphraseIterations, size)
+	if err != nil {
+		return nil, fmt.Errorf("pbkdf2: %w", err)
+	}
+	return key, nil
+}
+
+// DeriveGroupKey derives a key of the given size for the group from the secret using HKDF
+// with SHA-256. The group is usually the multicast address, so a single secret can serve
+// several groups, each with its own key.
+func DeriveGroupKey(secret []byte, group string, size int) ([]byte, error) {
+	if err := validateKeySize(size); err != nil {
+		return nil, err
+	}
+
+	key, err := hkdf.Key(sha256.New, secret, nil, "fader group key "+group, size)
+	if err != nil {
+		return nil, fmt.Errorf("hkdf: %w", err)
+	}
+	return key, nil
+}
+
+// LoadKeyFile reads a key from the file at the given path. The file can contain either the
+// raw key or its hex encoding. The file must not be accessible by group or others.
+func LoadKeyFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("key file %s has mode %s: %w", path, info.Mode().Perm(), ErrInsecureKeyFile)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+
+	key := content
+	if decoded, err := hex.DecodeString(string(bytes.TrimSpace(content))); err == nil {
+		key = decoded
+	}
+
+	if err := validateKeySize(len(key)); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func validateKeySize(size int) error {
+	if size != 16 && size != 24 && size != 32 {
+		return fmt.Errorf("key size %d: %w", size, ErrInvalidKeySize)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/posteo/fader/crypt"
+)
+
+func TestKeyDerivation(t *testing.T) {
+	t.Run("Passphrase", func(t *testing.T) {
+		keyOne, err := crypt.DeriveKeyFromPassphrase("secret", []byte("group one"), 32)
+		require.NoError(t, err)
+		assert.Len(t, keyOne, 32)
+
+		keyTwo, err := crypt.DeriveKeyFromPassphrase("secret", []byte("group two"), 32)
+		require.NoError(t, err)
+		assert.NotEqual(t, keyOne, keyTwo)
+
+		_, err = crypt.DeriveKeyFromPassphrase("secret", nil, 32)
+		assert.True(t, errors.Is(err, crypt.ErrMissingSalt))
+
+		_, err = crypt.DeriveKeyFromPassphrase("secret", []byte("group"), 20)
+		assert.True(t, errors.Is(err, crypt.ErrInvalidKeySize))
+	})
+
+	t.Run("Group", func(t *testing.T) {
+		keyOne, err := crypt.DeriveGroupKey(key, "224.0.0.1:1888", 16)
+		require.NoError(t, err)
+		assert.Len(t, keyOne, 16)
+
+		keyOneAgain, err := crypt.DeriveGroupKey(key, "224.0.0.1:1888", 16)
+		require.NoError(t, err)
+		assert.Equal(t, keyOne, keyOneAgain)
+
+		keyTwo, err := crypt.DeriveGroupKey(key, "224.0.0.1:1889", 16)
+		require.NoError(t, err)
+		assert.NotEqual(t, keyOne, keyTwo)
+	})
+
+	t.Run("File", func(t *testing.T) {
+		directory := t.TempDir()
+
+		rawPath := filepath.Join(directory, "raw.key")
+		require.NoError(t, os.WriteFile(rawPath, key, 0600))
+		loadedKey, err := crypt.LoadKeyFile(rawPath)
+		require.NoError(t, err)
+		assert.Equal(t, key, loadedKey)
+
+		hexPath := filepath.Join(directory, "hex.key")
+		require.NoError(t, os.WriteFile(hexPath, []byte(hex.EncodeToString(key)+"\n"), 0400))
+		loadedKey, err = crypt.LoadKeyFile(hexPath)
+		require.NoError(t, err)
+		assert.Equal(t, key, loadedKey)
+
+		insecurePath := filepath.Join(directory, "insecure.key")
+		require.NoError(t, os.WriteFile(insecurePath, key, 0644))
+		require.NoError(t, os.Chmod(insecurePath, 0644))
+		_, err = crypt.LoadKeyFile(insecurePath)
+		assert.True(t, errors.Is(err, crypt.ErrInsecureKeyFile))
+
+		shortPath := filepath.Join(directory, "short.key")
+		require.NoError(t, os.WriteFile(shortPath, []byte("short"), 0600))
+		_, err = crypt.LoadKeyFile(shortPath)
+		assert.True(t, errors.Is(err, crypt.ErrInvalidKeySize))
+	})
+}
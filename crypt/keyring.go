@@ -0,0 +1,219 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Keyring holds a set of keys, each identified by an id. The current key is used for
+// encryption, while every key in the ring is accepted for decryption. That way, a new
+// key can be distributed to all nodes first, then be made current and the old key can
+// be removed once no node uses it anymore.
+type Keyring struct {
+	mutex   sync.RWMutex
+	current uint32
+	entries map[uint32]*keyringEntry
+}
+
+// A keyring frame starts with the length of the cipher text, the cipher and the key id. A
+// legacy frame of NewEncrypter only has the length and an AES-GCM nonce.
+const (
+	keyringHeaderSize = 2 + 1 + 4
+	legacyNonceSize   = 12
+)
+
+type keyringEntry struct {
+	cipher    Cipher
+	aead      cipher.AEAD
+	encrypted uint64
+	decrypted uint64
+	lastUsed  int64
+}
+
+// KeyStats contains usage metrics of a key in the ring.
+type KeyStats struct {
+	ID        uint32
+	Cipher    Cipher
+	Current   bool
+	Encrypted uint64
+	Decrypted uint64
+	LastUsed  time.Time
+}
+
+var (
+	// ErrUnknownKey is returned if a key id is not part of the ring.
+	ErrUnknownKey = errors.New("unknown key id")
+
+	// ErrDuplicateKey is returned if a key id is already part of the ring.
+	ErrDuplicateKey = errors.New("duplicate key id")
+
+	// ErrCurrentKey is returned on an attempt to remove the current key.
+	ErrCurrentKey = errors.New("current key cannot be removed")
+)
+
+// NewKeyring returns a keyring that contains the given AES-GCM key as current key.
+func NewKeyring(id uint32, key []byte) (*Keyring, error) {
+	return NewKeyringWithCipher(id, AESGCM, key)
+}
+
+// NewKeyringWithCipher returns a keyring that contains the given key for the given cipher
+// as current key.
+func NewKeyringWithCipher(id uint32, c Cipher, key []byte) (*Keyring, error) {
+	k := &Keyring{
+		current: id,
+		entries: make(map[uint32]*keyringEntry),
+	}
+	if err := k.AddWithCipher(id, c, key); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Add places the AES-GCM key with the given id in the ring. From now on, frames that
+// have been encrypted with it, are accepted.
+func (k *Keyring) Add(id uint32, key []byte) error {
+	return k.AddWithCipher(id, AESGCM, key)
+}
+
+// AddWithCipher places the key for the given cipher with the given id in the ring. The
+// ring can hold keys of different ciphers, so a group can switch the cipher by rotating
+// the key.
+func (k *Keyring) AddWithCipher(id uint32, c Cipher, key []byte) error {
+	aead, err := c.NewAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if _, found := k.entries[id]; found {
+		return fmt.Errorf("key %d: %w", id, ErrDuplicateKey)
+	}
+	k.entries[id] = &keyringEntry{cipher: c, aead: aead}
+	return nil
+}
+
+// Remove drops the key with the given id from the ring. The current key can't be removed.
+func (k *Keyring) Remove(id uint32) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if _, found := k.entries[id]; !found {
+		return fmt.Errorf("key %d: %w", id, ErrUnknownKey)
+	}
+	if id == k.current {
+		return fmt.Errorf("key %d: %w", id, ErrCurrentKey)
+	}
+	delete(k.entries, id)
+	return nil
+}
+
+// SetCurrent makes the key with the given id the one that is used for encryption.
+func (k *Keyring) SetCurrent(id uint32) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if _, found := k.entries[id]; !found {
+		return fmt.Errorf("key %d: %w", id, ErrUnknownKey)
+	}
+	k.current = id
+	return nil
+}
+
+// Current returns the id of the key that is used for encryption.
+func (k *Keyring) Current() uint32 {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.current
+}
+
+// IDs returns the ids of all keys in the ring in ascending order.
+func (k *Keyring) IDs() []uint32 {
+	k.mutex.RLock()
+	ids := make([]uint32, 0, len(k.entries))
+	for id := range k.entries {
+		ids = append(ids, id)
+	}
+	k.mutex.RUnlock()
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Stats returns the usage metrics of all keys in the ring in ascending order of their
+// ids. A key that hasn't decrypted anything for a while is probably not used by any
+// node anymore and can be removed.
+func (k *Keyring) Stats() []KeyStats {
+	k.mutex.RLock()
+	stats := make([]KeyStats, 0, len(k.entries))
+	for id, entry := range k.entries {
+		s := KeyStats{
+			ID:        id,
+			Cipher:    entry.cipher,
+			Current:   id == k.current,
+			Encrypted: atomic.LoadUint64(&entry.encrypted),
+			Decrypted: atomic.LoadUint64(&entry.decrypted),
+		}
+		if lastUsed := atomic.LoadInt64(&entry.lastUsed); lastUsed != 0 {
+			s.LastUsed = time.Unix(0, lastUsed)
+		}
+		stats = append(stats, s)
+	}
+	k.mutex.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
+
+func (k *Keyring) currentEntry() (uint32, *keyringEntry) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.current, k.entries[k.current]
+}
+
+func (k *Keyring) entry(id uint32, c Cipher) (*keyringEntry, error) {
+	k.mutex.RLock()
+	entry, found := k.entries[id]
+	k.mutex.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("key %d: %w", id, ErrUnknownKey)
+	}
+	if entry.cipher != c {
+		return nil, fmt.Errorf("key %d is for %s, not %s: %w", id, entry.cipher, c, ErrCipherMismatch)
+	}
+	return entry, nil
+}
+
+func (e *keyringEntry) seal(nonce, plainText []byte) []byte {
+	atomic.AddUint64(&e.encrypted, 1)
+	atomic.StoreInt64(&e.lastUsed, time.Now().UnixNano())
+	return e.aead.Seal(nil, nonce, plainText, []byte{})
+}
+
+func (e *keyringEntry) open(nonce, cipherText []byte) ([]byte, error) {
+	plainText, err := e.aead.Open(nil, nonce, cipherText, []byte{})
+	if err != nil {
+		return nil, fmt.Errorf("aead open: %w", err)
+	}
+
+	atomic.AddUint64(&e.decrypted, 1)
+	atomic.StoreInt64(&e.lastUsed, time.Now().UnixNano())
+	return plainText, nil
+}
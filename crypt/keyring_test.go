@@ -0,0 +1,136 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypt_test
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/posteo/fader/crypt"
+)
+
+var otherKey = []byte{15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0}
+
+func TestKeyring(t *testing.T) {
+	t.Run("EncryptAndDecrypt", func(t *testing.T) {
+		keyring, err := crypt.NewKeyring(1, key)
+		require.NoError(t, err)
+
+		buffer := &bytes.Buffer{}
+		n, err := crypt.NewKeyringEncrypter(buffer, keyring).Write(big.NewInt(7), []byte{1, 2, 3})
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+
+		nonce := big.NewInt(0)
+		plainText := make([]byte, 3)
+		n, err = crypt.NewKeyringDecrypter(buffer, keyring).Read(nonce, plainText)
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+		assert.Equal(t, big.NewInt(7), nonce)
+		assert.Equal(t, []byte{1, 2, 3}, plainText)
+	})
+
+	t.Run("LegacyFrame", func(t *testing.T) {
+		keyring, err := crypt.NewKeyring(0, key)
+		require.NoError(t, err)
+
+		buffer := &bytes.Buffer{}
+		encrypter, err := crypt.NewEncrypter(buffer, key)
+		require.NoError(t, err)
+		_, err = encrypter.Write(big.NewInt(7), []byte{1, 2, 3})
+		require.NoError(t, err)
+
+		nonce := big.NewInt(0)
+		plainText := make([]byte, 3)
+		n, err := crypt.NewKeyringDecrypter(buffer, keyring).Read(nonce, plainText)
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+		assert.Equal(t, big.NewInt(7), nonce)
+		assert.Equal(t, []byte{1, 2, 3}, plainText)
+	})
+
+	t.Run("LegacyFrameWithoutKeyZero", func(t *testing.T) {
+		keyring, err := crypt.NewKeyring(1, key)
+		require.NoError(t, err)
+
+		buffer := &bytes.Buffer{}
+		encrypter, err := crypt.NewEncrypter(buffer, key)
+		require.NoError(t, err)
+		_, err = encrypter.Write(big.NewInt(7), []byte{1, 2, 3})
+		require.NoError(t, err)
+
+		_, err = crypt.NewKeyringDecrypter(buffer, keyring).Read(big.NewInt(0), make([]byte, 3))
+		assert.True(t, errors.Is(err, crypt.ErrUnknownKey))
+	})
+
+	t.Run("Rotation", func(t *testing.T) {
+		senderKeyring, err := crypt.NewKeyring(1, key)
+		require.NoError(t, err)
+		receiverKeyring, err := crypt.NewKeyring(1, key)
+		require.NoError(t, err)
+
+		require.NoError(t, receiverKeyring.Add(2, otherKey))
+		require.NoError(t, senderKeyring.Add(2, otherKey))
+		require.NoError(t, senderKeyring.SetCurrent(2))
+		require.NoError(t, senderKeyring.Remove(1))
+
+		buffer := &bytes.Buffer{}
+		_, err = crypt.NewKeyringEncrypter(buffer, senderKeyring).Write(big.NewInt(1), []byte{1, 2, 3})
+		require.NoError(t, err)
+
+		plainText := make([]byte, 3)
+		_, err = crypt.NewKeyringDecrypter(buffer, receiverKeyring).Read(big.NewInt(0), plainText)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{1, 2, 3}, plainText)
+
+		stats := receiverKeyring.Stats()
+		require.Len(t, stats, 2)
+		assert.Equal(t, uint32(1), stats[0].ID)
+		assert.True(t, stats[0].Current)
+		assert.Equal(t, uint64(0), stats[0].Decrypted)
+		assert.Equal(t, uint32(2), stats[1].ID)
+		assert.Equal(t, uint64(1), stats[1].Decrypted)
+		assert.False(t, stats[1].LastUsed.IsZero())
+	})
+
+	t.Run("UnknownKey", func(t *testing.T) {
+		senderKeyring, err := crypt.NewKeyring(2, otherKey)
+		require.NoError(t, err)
+		receiverKeyring, err := crypt.NewKeyring(1, key)
+		require.NoError(t, err)
+
+		buffer := &bytes.Buffer{}
+		_, err = crypt.NewKeyringEncrypter(buffer, senderKeyring).Write(big.NewInt(1), []byte{1, 2, 3})
+		require.NoError(t, err)
+
+		_, err = crypt.NewKeyringDecrypter(buffer, receiverKeyring).Read(big.NewInt(0), make([]byte, 3))
+		assert.True(t, errors.Is(err, crypt.ErrUnknownKey))
+	})
+
+	t.Run("RemoveCurrentKey", func(t *testing.T) {
+		keyring, err := crypt.NewKeyring(1, key)
+		require.NoError(t, err)
+
+		assert.True(t, errors.Is(keyring.Remove(1), crypt.ErrCurrentKey))
+		assert.True(t, errors.Is(keyring.Add(1, otherKey), crypt.ErrDuplicateKey))
+		assert.True(t, errors.Is(keyring.SetCurrent(3), crypt.ErrUnknownKey))
+		assert.Equal(t, []uint32{1}, keyring.IDs())
+	})
+}
@@ -42,9 +42,19 @@
 //    time.Sleep(10*time.Millisecond)
 //
 //    multicastFaderTwo.Size() // => 1
+//
+// Frames carry the id of the key they have been encrypted with. Nodes read the frames
+// of releases without key ids using key 0 of their ring, but those releases can't read
+// the frames of newer nodes. All nodes of a group should be upgraded at once.
 package fader
 
-import "time"
+import (
+	"errors"
+	"time"
+)
+
+// ErrClosed is returned if an item is put into a fader, that has been closed.
+var ErrClosed = errors.New("fader closed")
 
 // Fader defines the fader interface.
 type Fader interface {
@@ -56,3 +66,8 @@ type Fader interface {
 	Clear()
 	Close() error
 }
+
+// Ranger is implemented by faders that can iterate over all their items.
+type Ranger interface {
+	Range(func([]byte, time.Time, []byte) bool)
+}
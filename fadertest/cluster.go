@@ -0,0 +1,133 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fadertest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/posteo/fader"
+	"github.com/posteo/fader/crypt"
+)
+
+const pollInterval = 10 * time.Millisecond
+
+var key = []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// Node is a member of a simulated cluster, that consists of a memory fader, which is
+// replicated by a multicast fader.
+type Node struct {
+	Address   string
+	ID        []byte
+	Memory    *fader.Memory
+	Multicast *fader.Multicast
+}
+
+// Cluster starts the given number of nodes on the network. Their addresses are "node-0",
+// "node-1" and so on. The nodes are closed when the test finishes.
+func (n *Network) Cluster(tb testing.TB, count int, expiresIn time.Duration) []*Node {
+	tb.Helper()
+
+	keyring, err := crypt.NewKeyring(0, key)
+	if err != nil {
+		tb.Fatalf("new keyring: %v", err)
+	}
+
+	nodes := make([]*Node, 0, count)
+	for index := range count {
+		address := fmt.Sprintf("node-%d", index)
+		transport, err := n.Transport(address)
+		if err != nil {
+			tb.Fatalf("transport [%s]: %v", address, err)
+		}
+
+		id := make([]byte, 10)
+		binary.BigEndian.PutUint64(id[2:], uint64(index))
+
+		node := &Node{
+			Address: address,
+			ID:      id,
+			Memory:  fader.NewMemory(expiresIn),
+		}
+		node.Multicast, err = fader.NewMulticastWithTransport(node.Memory, transport, keyring, id, nil)
+		if err != nil {
+			tb.Fatalf("multicast fader [%s]: %v", address, err)
+		}
+		tb.Cleanup(func() {
+			node.Multicast.Close()
+			node.Memory.Close()
+		})
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// Converged returns true if the memory faders of all nodes hold the same items.
+func Converged(nodes []*Node) bool {
+	if len(nodes) == 0 {
+		return true
+	}
+
+	expected := snapshot(nodes[0].Memory)
+	for _, node := range nodes[1:] {
+		items := snapshot(node.Memory)
+		if len(items) != len(expected) {
+			return false
+		}
+		for item := range expected {
+			if _, found := items[item]; !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// AssertConverged waits until the nodes have converged and fails the test if they don't
+// within the timeout.
+func AssertConverged(tb testing.TB, nodes []*Node, timeout time.Duration) bool {
+	tb.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !Converged(nodes) {
+		if time.Now().After(deadline) {
+			sizes := make([]int, len(nodes))
+			for index, node := range nodes {
+				sizes[index] = node.Memory.Size()
+			}
+			tb.Errorf("nodes didn't converge within %s, sizes %v", timeout, sizes)
+			return false
+		}
+		time.Sleep(pollInterval)
+	}
+	return true
+}
+
+type snapshotItem struct {
+	key   string
+	time  int64
+	value string
+}
+
+func snapshot(memory *fader.Memory) map[snapshotItem]struct{} {
+	items := make(map[snapshotItem]struct{})
+	memory.Range(func(key []byte, t time.Time, value []byte) bool {
+		items[snapshotItem{string(key), t.UnixNano(), string(value)}] = struct{}{}
+		return true
+	})
+	return items
+}
@@ -0,0 +1,274 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fadertest provides a simulated network and helpers to test the replication
+// of a group of faders without a real network.
+package fadertest
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/posteo/fader"
+)
+
+const queueSize = 1024
+
+// Network simulates an unreliable network on top of a fader.Bus. Frames can be lost,
+// delayed, duplicated and reordered, and the network can be split into partitions.
+// The random decisions are made by a seeded source, so a failing run can be repeated.
+type Network struct {
+	bus        *fader.Bus
+	mutex      sync.Mutex
+	random     *rand.Rand
+	loss       float64
+	duplicate  float64
+	latency    time.Duration
+	jitter     time.Duration
+	partitions map[string]int
+}
+
+// NewNetwork returns a network, that delivers all frames right away.
+func NewNetwork(seed uint64) *Network {
+	return &Network{
+		bus:        fader.NewBus(),
+		random:     rand.New(rand.NewPCG(seed, seed)),
+		partitions: make(map[string]int),
+	}
+}
+
+// SetLoss sets the probability between 0 and 1, that a frame is lost on its way to a
+// receiver.
+func (n *Network) SetLoss(rate float64) {
+	n.mutex.Lock()
+	n.loss = rate
+	n.mutex.Unlock()
+}
+
+// SetDuplication sets the probability between 0 and 1, that a frame is delivered twice.
+func (n *Network) SetDuplication(rate float64) {
+	n.mutex.Lock()
+	n.duplicate = rate
+	n.mutex.Unlock()
+}
+
+// SetLatency sets the delay of each frame. A random jitter of up to the given duration
+// is added, which reorders frames that are sent in quick succession.
+func (n *Network) SetLatency(latency, jitter time.Duration) {
+	n.mutex.Lock()
+	n.latency, n.jitter = latency, jitter
+	n.mutex.Unlock()
+}
+
+// Partition splits the network into the given groups of addresses. Frames are only
+// delivered within a group. Addresses, that are not part of any group, can still reach
+// and be reached by all others.
+func (n *Network) Partition(groups ...[]string) {
+	n.mutex.Lock()
+	n.partitions = make(map[string]int)
+	for index, group := range groups {
+		for _, address := range group {
+			n.partitions[address] = index
+		}
+	}
+	n.mutex.Unlock()
+}
+
+// Heal removes all partitions.
+func (n *Network) Heal() {
+	n.Partition()
+}
+
+// Transport attaches a new transport with the given address to the network.
+func (n *Network) Transport(address string) (fader.Transport, error) {
+	inner, err := n.bus.Transport(address)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &transport{
+		network:   n,
+		address:   address,
+		inner:     inner,
+		incoming:  make(chan frame, queueSize),
+		scheduled: make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	go t.receiveLoop()
+	go t.deliverLoop()
+
+	return t, nil
+}
+
+// delays returns the delays for each copy of a frame from the source to the destination.
+// If the frame is lost, no delay is returned.
+func (n *Network) delays(source, destination string) []time.Duration {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	sourcePartition, sourceFound := n.partitions[source]
+	destinationPartition, destinationFound := n.partitions[destination]
+	if sourceFound && destinationFound && sourcePartition != destinationPartition {
+		return nil
+	}
+
+	count := 1
+	if n.random.Float64() < n.duplicate {
+		count++
+	}
+
+	delays := []time.Duration{}
+	for range count {
+		if n.random.Float64() < n.loss {
+			continue
+		}
+		delay := n.latency
+		if n.jitter > 0 {
+			delay += time.Duration(n.random.Int64N(int64(n.jitter)))
+		}
+		delays = append(delays, delay)
+	}
+	return delays
+}
+
+type frame struct {
+	source net.Addr
+	data   []byte
+}
+
+// scheduled is a frame, that is delivered at the given time. Frames with the same time
+// are delivered in the order they have been scheduled.
+type scheduled struct {
+	at       time.Time
+	sequence uint64
+	frame    frame
+}
+
+type schedule []scheduled
+
+func (s schedule) Len() int { return len(s) }
+
+func (s schedule) Less(i, j int) bool {
+	if s[i].at.Equal(s[j].at) {
+		return s[i].sequence < s[j].sequence
+	}
+	return s[i].at.Before(s[j].at)
+}
+
+func (s schedule) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+func (s *schedule) Push(x any) { *s = append(*s, x.(scheduled)) }
+
+func (s *schedule) Pop() any {
+	old := *s
+	x := old[len(old)-1]
+	*s = old[:len(old)-1]
+	return x
+}
+
+type transport struct {
+	network   *Network
+	address   string
+	inner     fader.Transport
+	incoming  chan frame
+	mutex     sync.Mutex
+	schedule  schedule
+	sequence  uint64
+	scheduled chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (t *transport) Send(data []byte) error {
+	return t.inner.Send(data)
+}
+
+func (t *transport) Receive(buffer []byte) (int, net.Addr, error) {
+	select {
+	case f := <-t.incoming:
+		return copy(buffer, f.data), f.source, nil
+	case <-t.done:
+		return 0, nil, fmt.Errorf("receive frame: %w", net.ErrClosed)
+	}
+}
+
+func (t *transport) Close() error {
+	t.closeOnce.Do(func() { close(t.done) })
+	return t.inner.Close()
+}
+
+// receiveLoop receives the frames from the bus and schedules them according to the
+// conditions of the network.
+func (t *transport) receiveLoop() {
+	buffer := make([]byte, 65535)
+	for {
+		n, source, err := t.inner.Receive(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		f := frame{source: source, data: append([]byte{}, buffer[:n]...)}
+
+		now := time.Now()
+		t.mutex.Lock()
+		for _, delay := range t.network.delays(source.String(), t.address) {
+			t.sequence++
+			heap.Push(&t.schedule, scheduled{at: now.Add(delay), sequence: t.sequence, frame: f})
+		}
+		t.mutex.Unlock()
+
+		select {
+		case t.scheduled <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// deliverLoop passes the scheduled frames on once they are due. Like a real network,
+// it drops frames if the receiver doesn't keep up.
+func (t *transport) deliverLoop() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		t.mutex.Lock()
+		for len(t.schedule) > 0 && !t.schedule[0].at.After(time.Now()) {
+			next := heap.Pop(&t.schedule).(scheduled)
+			select {
+			case t.incoming <- next.frame:
+			default:
+			}
+		}
+		wait := time.Hour
+		if len(t.schedule) > 0 {
+			wait = time.Until(t.schedule[0].at)
+		}
+		t.mutex.Unlock()
+
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-t.scheduled:
+		case <-t.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fadertest_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/posteo/fader/fadertest"
+)
+
+func TestNetworkReplication(t *testing.T) {
+	network := fadertest.NewNetwork(1)
+	network.SetLatency(time.Millisecond, 0)
+	network.SetDuplication(0.5)
+	nodes := network.Cluster(t, 3, time.Minute)
+
+	for index := range 10 {
+		require.NoError(t, nodes[index%3].Multicast.Put([]byte(fmt.Sprintf("key %d", index)), time.Now(), []byte("value")))
+	}
+
+	fadertest.AssertConverged(t, nodes, time.Second)
+	assert.Equal(t, 10, nodes[0].Memory.Size())
+}
+
+// Reordered frames are dropped by the replay protection, so both loss and jitter need to
+// be repaired by anti-entropy.
+func TestNetworkLossIsRepaired(t *testing.T) {
+	network := fadertest.NewNetwork(2)
+	network.SetLoss(0.5)
+	network.SetLatency(time.Millisecond, 5*time.Millisecond)
+	nodes := network.Cluster(t, 3, time.Minute)
+	for _, node := range nodes {
+		node.Multicast.SetAntiEntropyInterval(20 * time.Millisecond)
+	}
+
+	past := time.Now().Add(-3 * time.Second)
+	for index := range 20 {
+		require.NoError(t, nodes[index%3].Multicast.Put([]byte(fmt.Sprintf("key %d", index)), past, []byte("value")))
+	}
+
+	fadertest.AssertConverged(t, nodes, 2*time.Second)
+	assert.Equal(t, 20, nodes[0].Memory.Size())
+}
+
+func TestNetworkPartition(t *testing.T) {
+	network := fadertest.NewNetwork(3)
+	nodes := network.Cluster(t, 2, time.Minute)
+	for _, node := range nodes {
+		node.Multicast.SetAntiEntropyInterval(20 * time.Millisecond)
+	}
+
+	network.Partition([]string{nodes[0].Address}, []string{nodes[1].Address})
+	require.NoError(t, nodes[0].Multicast.Put([]byte("key"), time.Now().Add(-3*time.Second), []byte("value")))
+	time.Sleep(50 * time.Millisecond)
+
+	assert.False(t, fadertest.Converged(nodes))
+	assert.Equal(t, 0, nodes[1].Memory.Size())
+
+	network.Heal()
+	fadertest.AssertConverged(t, nodes, 2*time.Second)
+}
@@ -1,8 +1,15 @@
 module github.com/posteo/fader
 
 require (
-	github.com/stretchr/objx v0.2.0 // indirect
 	github.com/stretchr/testify v1.3.0
+	golang.org/x/crypto v0.54.0
+	golang.org/x/net v0.57.0
 )
 
-go 1.13
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+)
+
+go 1.25.0
@@ -17,6 +17,7 @@ package fader
 import (
 	"bytes"
 	"container/heap"
+	"context"
 	"log"
 	"sync"
 	"time"
@@ -29,6 +30,15 @@ type Memory struct {
 	itemsMutex sync.RWMutex
 	itemStored chan struct{}
 	closed     chan struct{}
+	closeOnce  sync.Once
+	stopped    chan struct{}
+	logger     *log.Logger
+	now        func() time.Time
+}
+
+// MemoryOption configures a memory fader.
+type MemoryOption interface {
+	applyMemory(*Memory) error
 }
 
 var (
@@ -42,11 +52,27 @@ func init() {
 // NewMemory creates a Fader instance that stores all data in the Memory. The expiresIn
 // parameter defines after which period a stored item will be removed.
 func NewMemory(expiresIn time.Duration) *Memory {
+	// Without any options, no error can occur.
+	m, _ := NewMemoryWithOptions(expiresIn)
+	return m
+}
+
+// NewMemoryWithOptions creates a Fader instance like NewMemory and applies the given
+// options. An error is returned if an option is invalid.
+func NewMemoryWithOptions(expiresIn time.Duration, options ...MemoryOption) (*Memory, error) {
 	m := &Memory{
 		expiresIn:  expiresIn,
 		items:      itemHeap{},
 		itemStored: make(chan struct{}),
 		closed:     make(chan struct{}),
+		stopped:    make(chan struct{}),
+		logger:     log.Default(),
+		now:        time.Now,
+	}
+	for _, option := range options {
+		if err := option.applyMemory(m); err != nil {
+			return nil, err
+		}
 	}
 
 	m.itemsMutex.Lock()
@@ -55,11 +81,18 @@ func NewMemory(expiresIn time.Duration) *Memory {
 
 	go m.expiryLoop()
 
-	return m
+	return m, nil
 }
 
-// Put places an item with the provided key, time and value in the fader.
+// Put places an item with the provided key, time and value in the fader. After the fader
+// has been closed, ErrClosed is returned.
 func (m *Memory) Put(key []byte, t time.Time, value []byte) error {
+	select {
+	case <-m.closed:
+		return ErrClosed
+	default:
+	}
+
 	m.itemsMutex.Lock()
 	heap.Push(&m.items, &item{
 		key:   key,
@@ -68,7 +101,7 @@ func (m *Memory) Put(key []byte, t time.Time, value []byte) error {
 	})
 	m.itemsMutex.Unlock()
 
-	m.itemStored <- struct{}{}
+	m.notifyExpiryLoop()
 
 	return nil
 }
@@ -114,6 +147,23 @@ func (m *Memory) Select(key []byte) ([]time.Time, [][]byte) {
 	return times, values
 }
 
+// Range calls the given function for each item in the fader until it returns false. The
+// function is called on a snapshot of the items, so it can safely access the fader.
+func (m *Memory) Range(f func([]byte, time.Time, []byte) bool) {
+	m.itemsMutex.RLock()
+	items := make([]item, len(m.items))
+	for index, item := range m.items {
+		items[index] = *item
+	}
+	m.itemsMutex.RUnlock()
+
+	for _, item := range items {
+		if !f(item.key, item.time, item.value) {
+			return
+		}
+	}
+}
+
 // Size returns the number of items in the fader.
 func (m *Memory) Size() int {
 	m.itemsMutex.RLock()
@@ -129,13 +179,34 @@ func (m *Memory) Clear() {
 	heap.Init(&m.items)
 	m.itemsMutex.Unlock()
 
-	m.itemStored <- struct{}{}
+	m.notifyExpiryLoop()
 }
 
-// Close tears down the fader.
+// Close tears down the fader. See Shutdown.
 func (m *Memory) Close() error {
-	m.closed <- struct{}{}
-	return nil
+	return m.Shutdown(context.Background())
+}
+
+// Shutdown stops the expiry of items and waits until the expiry goroutine has returned or
+// the context is done. It's safe to call Shutdown multiple times.
+func (m *Memory) Shutdown(ctx context.Context) error {
+	m.closeOnce.Do(func() { close(m.closed) })
+
+	select {
+	case <-m.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// notifyExpiryLoop passes a change of the items to the expiry loop, unless it has been
+// stopped.
+func (m *Memory) notifyExpiryLoop() {
+	select {
+	case m.itemStored <- struct{}{}:
+	case <-m.stopped:
+	}
 }
 
 func (m *Memory) removeEarliest() *item {
@@ -150,7 +221,7 @@ func (m *Memory) removeEarliest() *item {
 }
 
 // This function should run in it's own goroutine. It runs in an infinite loop
-// until something is send through the m.closed channel.
+// until the m.closed channel is closed.
 // If a new item is stored, the earliest item is fetched from the heap and the
 // duration to it's expiry is calculated. Even if the earliest item hasn't
 // changed, this calculation is needed, because the duration to it's expiry
@@ -160,6 +231,8 @@ func (m *Memory) removeEarliest() *item {
 // If no items left, the function returns to it's initial state where it waits
 // for an item to be stored.
 func (m *Memory) expiryLoop() {
+	defer close(m.stopped)
+
 	durationTillNextExpiry := veryLongDuration
 
 	expiryDelay := time.NewTimer(durationTillNextExpiry)
@@ -167,7 +240,7 @@ func (m *Memory) expiryLoop() {
 
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("panic: %v", r)
+			m.logger.Printf("panic: %v", r)
 		}
 	}()
 
@@ -190,7 +263,7 @@ func (m *Memory) findNextDurationTillNextExpiry() time.Duration {
 	result := veryLongDuration
 
 	for k, t, _ := m.Earliest(); k != nil; k, t, _ = m.Earliest() {
-		duration := t.Sub(time.Now().Add(-m.expiresIn))
+		duration := t.Sub(m.now().Add(-m.expiresIn))
 		if duration > 0 {
 			result = duration
 			break
@@ -15,6 +15,8 @@
 package fader_test
 
 import (
+	"context"
+	"errors"
 	"strconv"
 	"sync"
 	"testing"
@@ -69,6 +71,29 @@ func TestMemory(t *testing.T) {
 		assert.Equal(t, "value one", string(values[0]))
 	})
 
+	t.Run("Range", func(t *testing.T) {
+		fader := fader.NewMemory(50 * time.Millisecond)
+
+		now := time.Now()
+
+		require.NoError(t, fader.Put([]byte("one"), now, []byte("value one")))
+		require.NoError(t, fader.Put([]byte("two"), now, []byte("value two")))
+
+		keys := []string{}
+		fader.Range(func(key []byte, _ time.Time, _ []byte) bool {
+			keys = append(keys, string(key))
+			return true
+		})
+		assert.ElementsMatch(t, []string{"one", "two"}, keys)
+
+		count := 0
+		fader.Range(func([]byte, time.Time, []byte) bool {
+			count++
+			return false
+		})
+		assert.Equal(t, 1, count)
+	})
+
 	t.Run("Expiry", func(t *testing.T) {
 		fader := fader.NewMemory(50 * time.Millisecond)
 
@@ -122,6 +147,35 @@ func TestMemory(t *testing.T) {
 		assert.Nil(t, key)
 	})
 
+	t.Run("WithClock", func(t *testing.T) {
+		now := time.Now()
+		fader, err := fader.NewMemoryWithOptions(time.Minute, fader.WithClock(func() time.Time {
+			return now.Add(time.Hour)
+		}))
+		require.NoError(t, err)
+
+		require.NoError(t, fader.Put([]byte("key"), now, []byte("value")))
+		time.Sleep(10 * time.Millisecond)
+
+		assert.Equal(t, 0, fader.Size())
+	})
+
+	t.Run("InvalidOption", func(t *testing.T) {
+		_, err := fader.NewMemoryWithOptions(time.Minute, fader.WithLogger(nil))
+		assert.True(t, errors.Is(err, fader.ErrInvalidOption))
+	})
+
+	t.Run("Shutdown", func(t *testing.T) {
+		memoryFader := fader.NewMemory(time.Minute)
+
+		require.NoError(t, memoryFader.Shutdown(context.Background()))
+		require.NoError(t, memoryFader.Close())
+
+		err := memoryFader.Put([]byte("key"), time.Now(), []byte("value"))
+		assert.True(t, errors.Is(err, fader.ErrClosed))
+		memoryFader.Clear()
+	})
+
 	t.Run("ConcurrentPut", func(t *testing.T) {
 		fader := fader.NewMemory(time.Second)
 
@@ -15,11 +15,15 @@
 package fader
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"net"
-	"strings"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/posteo/fader/crypt"
@@ -27,21 +31,94 @@ import (
 
 // Multicast implements a multicast faader.
 type Multicast struct {
-	parent              Fader
-	address             string
-	key                 []byte
-	id                  []byte
-	itemReceivedHandler ReceivedHandler
-	incomingConnection  *net.UDPConn
-	outgoingConnection  *net.UDPConn
-	transmitter         *multicastTransmitter
+	parent                Fader
+	keyring               *crypt.Keyring
+	id                    []byte
+	itemHandler           ItemHandler
+	errorHandler          ErrorHandler
+	logger                *log.Logger
+	now                   func() time.Time
+	transport             Transport
+	transmitter           *multicastTransmitter
+	counters              multicastCounters
+	maximalItemSize       atomic.Int64
+	reassemblyTimeout     time.Duration
+	reassemblyMemory      int
+	messageID             atomic.Uint32
+	flushInterval         atomic.Int64
+	sendMutex             sync.Mutex
+	sendClosed            bool
+	flushTimer            *time.Timer
+	syncRate              atomic.Int64
+	syncResponding        atomic.Bool
+	lastSyncResponse      atomic.Int64
+	antiEntropyInterval   atomic.Int64
+	antiEntropyRate       atomic.Int64
+	antiEntropyResponding atomic.Bool
+	antiEntropyReset      chan struct{}
+	clearedAt             atomic.Int64
+	clearMutex            sync.RWMutex
+	peers                 *peerTable
+	channels              *channelTable
+	label                 atomic.Value
+	heartbeatInterval     atomic.Int64
+	heartbeatReset        chan struct{}
+	accessPolicy          atomic.Pointer[accessPolicy]
+	rateLimiter           atomic.Pointer[rateLimiter]
+	ownsParent            bool
+	syncOnJoin            bool
+	goroutines            sync.WaitGroup
+	done                  chan struct{}
+	closeOnce             sync.Once
+	closeErr              error
+	parentOnce            sync.Once
+	parentErr             error
 }
 
 // ReceivedHandler defines a handler for received items.
 type ReceivedHandler func([]byte, time.Time, []byte) bool
 
+// ReceivedItem describes an item, that has been received from a peer. The handler may
+// modify key, time and value before the item is stored in the parent fader.
+type ReceivedItem struct {
+	Key   []byte
+	Time  time.Time
+	Value []byte
+
+	// Sender is the id of the node, that has sent the item.
+	Sender []byte
+	// Source is the address, the frame has been received from.
+	Source net.Addr
+	// Nonce is the nonce of the frame.
+	Nonce *big.Int
+	// ReceivedAt is the time, the frame has been received. For fragmented items, it's
+	// the time of the last fragment.
+	ReceivedAt time.Time
+	// Size is the size of the encoded packet. For fragmented items, it's the size of the
+	// reassembled packet.
+	Size int
+}
+
+// ItemHandler defines a handler for received items, that gets the metadata of the item
+// and may modify it. If the handler returns false, the item is dismissed.
+type ItemHandler func(*ReceivedItem) bool
+
+func newReceivedItem(frame *receivedFrame, mp *multicastPacket, size int) *ReceivedItem {
+	return &ReceivedItem{
+		Key:        mp.key,
+		Time:       mp.time,
+		Value:      mp.value,
+		Sender:     frame.sender,
+		Source:     frame.source,
+		Nonce:      frame.nonce,
+		ReceivedAt: frame.receivedAt,
+		Size:       size,
+	}
+}
+
 // ErrInvalidKeyLength is returns if a key with an invalid length is provided. Valid lengths
-// are 16, 24 and 32.
+// are 16, 24 and 32. See crypt.DeriveKeyFromPassphrase and crypt.DeriveGroupKey to get a key
+// of a valid length.
 var ErrInvalidKeyLength = errors.New("invalid key length")
 
 // NewMulticast creates a Fader instance that delegates all calls to a parent Fader instance.
@@ -54,6 +131,8 @@ var ErrInvalidKeyLength = errors.New("invalid key length")
 // The argument can take a function that is called every time an item is received.
 // If the function is nil or returns true, the received item will be stored in
 // the parent fader. Otherwise, the item will be dismissed.
+// The key is placed with id 0 in a keyring, that can be accessed via Keyring to
+// rotate keys later on.
 func NewMulticast(
 	parent Fader,
 	address string,
@@ -61,56 +140,160 @@ func NewMulticast(
 	id []byte,
 	itemReceivedHandler ReceivedHandler,
 ) (*Multicast, error) {
+	return NewMulticastWithOptions(parent,
+		WithAddress(address),
+		WithKey(key),
+		optionalID(id),
+		WithReceivedHandler(itemReceivedHandler))
+}
+
+// NewMulticastWithKeyring creates a Fader instance like NewMulticast, but takes a keyring
+// instead of a single key. Packets are encrypted with the current key of the ring and
+// every key of the ring is accepted for decryption. Keys can be added to and removed from
+// the ring at runtime, which allows to rotate the key of a group without restarting
+// all nodes at once.
+func NewMulticastWithKeyring(
+	parent Fader,
+	address string,
+	keyring *crypt.Keyring,
+	id []byte,
+	itemReceivedHandler ReceivedHandler,
+) (*Multicast, error) {
+	return NewMulticastWithOptions(parent,
+		WithAddress(address),
+		WithKeyring(keyring),
+		optionalID(id),
+		WithReceivedHandler(itemReceivedHandler))
+}
+
+// NewMulticastWithTransport creates a Fader instance like NewMulticastWithKeyring, but
+// exchanges the packets via the given transport instead of udp multicast. The transport
+// is closed together with the fader.
+func NewMulticastWithTransport(
+	parent Fader,
+	transport Transport,
+	keyring *crypt.Keyring,
+	id []byte,
+	itemReceivedHandler ReceivedHandler,
+) (*Multicast, error) {
+	return NewMulticastWithOptions(parent,
+		WithTransport(transport),
+		WithKeyring(keyring),
+		optionalID(id),
+		WithReceivedHandler(itemReceivedHandler))
+}
+
+func newMulticast(parent Fader, o *multicastOptions) *Multicast {
 	m := &Multicast{
-		parent:              parent,
-		address:             address,
-		key:                 key,
-		id:                  id,
-		itemReceivedHandler: itemReceivedHandler,
+		parent:            parent,
+		transport:         o.transport,
+		keyring:           o.keyring,
+		id:                o.id,
+		itemHandler:       o.itemHandler,
+		errorHandler:      o.errorHandler,
+		ownsParent:        o.ownsParent,
+		syncOnJoin:        o.syncOnJoin,
+		logger:            o.logger,
+		now:               o.now,
+		reassemblyTimeout: o.reassemblyTimeout,
+		reassemblyMemory:  o.reassemblyMemory,
+		done:              make(chan struct{}),
+		antiEntropyReset:  make(chan struct{}, 1),
+		heartbeatReset:    make(chan struct{}, 1),
+		peers:             newPeerTable(),
+		channels:          newChannelTable(),
 	}
-
-	if length := len(m.key); length != 16 && length != 24 && length != 32 {
-		return nil, fmt.Errorf("key length %d: %w", length, ErrInvalidKeyLength)
+	if handler := o.itemReceivedHandler; handler != nil {
+		m.itemHandler = func(item *ReceivedItem) bool {
+			return handler(item.Key, item.Time, item.Value)
+		}
 	}
-
-	udpAddress, err := net.ResolveUDPAddr("udp", m.address)
-	if err != nil {
-		return nil, fmt.Errorf("resolve udp address [%s]: %w", m.address, err)
+	if m.logger == nil {
+		m.logger = log.Default()
 	}
-
-	m.incomingConnection, err = net.ListenMulticastUDP("udp", nil, udpAddress)
-	if err != nil {
-		return nil, fmt.Errorf("listen Multicast udp: %w", err)
+	if transport, ok := m.transport.(loggingTransport); ok {
+		transport.setLogger(m.logger)
 	}
-
-	m.outgoingConnection, err = net.DialUDP("udp", nil, udpAddress)
-	if err != nil {
-		return nil, fmt.Errorf("dial udp: %w", err)
+	if m.now == nil {
+		m.now = time.Now
 	}
-
-	decrypter, err := crypt.NewDecrypter(m.incomingConnection, m.key)
-	if err != nil {
-		return nil, fmt.Errorf("new decrypter: %w", err)
+	if m.reassemblyTimeout == 0 {
+		m.reassemblyTimeout = defaultReassemblyTimeout
+	}
+	if m.reassemblyMemory == 0 {
+		m.reassemblyMemory = defaultReassemblyMemory
 	}
+	m.maximalItemSize.Store(defaultMaximalItemSize)
+	m.syncRate.Store(defaultSyncRate)
+	m.antiEntropyRate.Store(defaultAntiEntropyRate)
+	m.heartbeatInterval.Store(int64(defaultHeartbeatInterval))
 
-	encrypter, err := crypt.NewEncrypter(m.outgoingConnection, m.key)
+	label, err := os.Hostname()
 	if err != nil {
-		return nil, fmt.Errorf("new encrypter: %w", err)
+		label = "unknown"
 	}
+	m.label.Store(label)
+
+	m.transmitter = newMulticastTransmitter(m.transport, m.keyring, m.id, o.signer, m.logger)
+	m.transmitter.admit = m.withinGlobalLimit
+
+	return m
+}
 
-	m.transmitter = newMulticastTransmitter(encrypter, decrypter, m.id)
+func (m *Multicast) start() {
+	m.goroutines.Go(m.receiveLoop)
+	m.goroutines.Go(m.antiEntropyLoop)
+	m.goroutines.Go(m.heartbeatLoop)
 
-	go m.receiveLoop()
+	if m.syncOnJoin {
+		if err := m.RequestSync(); err != nil {
+			m.logger.Printf("request sync on join: %v", err)
+		}
+	}
+}
 
-	return m, nil
+// optionalID keeps the behaviour of the old constructors, that generate a random id if
+// the given one doesn't have the right length.
+func optionalID(id []byte) MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		if len(id) == idSize {
+			o.id = id
+		}
+		return nil
+	})
 }
 
-// Put places an item with the provided key, time and value in the fader.
+// Put places an item with the provided key, time and value in the fader. An item, that
+// doesn't fit into a single packet, is split into fragments. An item, that is not newer
+// than the latest clear of the group, is rejected with ErrStaleItem, since the other nodes
+// would drop it. After the fader has been shut down, ErrClosed is returned.
 func (m *Multicast) Put(key []byte, time time.Time, value []byte) error {
-	if err := m.send(key, time, value); err != nil {
+	select {
+	case <-m.done:
+		return ErrClosed
+	default:
+	}
+	if m.stale(time) {
+		return fmt.Errorf("item of %v: %w", time, ErrStaleItem)
+	}
+	if size := len(key) + len(value); int64(size) > m.maximalItemSize.Load() {
+		return fmt.Errorf("item of size %d: %w", size, ErrItemTooLarge)
+	}
+	if err := m.send(&multicastPacket{operation: operationPut, key: key, time: time, value: value}); err != nil {
 		return fmt.Errorf("send item: %w", err)
 	}
-	return m.parent.Put(key, time, value)
+	put, err := m.putUnlessStale(key, time, value)
+	if !put {
+		return fmt.Errorf("item of %v: %w", time, ErrStaleItem)
+	}
+	return err
+}
+
+// Range calls the given function for each item in the parent fader, if it implements Ranger.
+func (m *Multicast) Range(f func([]byte, time.Time, []byte) bool) {
+	if ranger, ok := m.parent.(Ranger); ok {
+		ranger.Range(f)
+	}
 }
 
 // Get returns time and value for the provided key. If no such key exists, a value
@@ -134,67 +317,315 @@ func (m *Multicast) Size() int {
 	return m.parent.Size()
 }
 
-// Clear performs a clear on the parent fader.
+// Clear performs a clear on the parent fader only. See ClearAll to clear the whole group.
 func (m *Multicast) Clear() {
-	m.parent.Clear()
+	m.ClearLocal()
+}
+
+// Keyring returns the keyring that is used to encrypt and decrypt packets.
+func (m *Multicast) Keyring() *crypt.Keyring {
+	return m.keyring
+}
+
+// SetMaximalItemSize sets the maximal size of key and value of an item. Larger items are
+// rejected by Put and fragments of larger received items are dropped. The default is 1 MiB.
+func (m *Multicast) SetMaximalItemSize(size int) {
+	m.maximalItemSize.Store(int64(size))
 }
 
-// Close tears down the fader.
+// SetFlushInterval sets the period for which packets are held back in order to send them
+// together in a single datagram. This trades a tiny latency for far fewer datagrams and
+// encryptions. A packet is never held back longer than that period, and the buffer is
+// flushed earlier once it's full. The default of zero sends every packet right away.
+func (m *Multicast) SetFlushInterval(interval time.Duration) {
+	m.flushInterval.Store(int64(interval))
+}
+
+// Stats returns the counters of the fader.
+func (m *Multicast) Stats() MulticastStats {
+	stats := m.counters.stats()
+	if transport, ok := m.transport.(droppingTransport); ok {
+		stats.DroppedFrames = transport.droppedFrames()
+	}
+	return stats
+}
+
+// Close shuts down the fader without a deadline. See Shutdown.
 func (m *Multicast) Close() error {
-	if err := m.incomingConnection.Close(); err != nil {
-		return fmt.Errorf("close incoming connection: %w", err)
+	return m.Shutdown(context.Background())
+}
+
+// Shutdown stops accepting items, flushes pending packets, announces to the peers that
+// this node leaves and closes the transport. The announcement is the last frame, that is
+// sent, so the peers don't consider the node alive again. Afterwards, it waits until the items that
+// are currently received have been put into the parent fader and all goroutines have
+// returned, or until the context is done. If the fader owns its parent, the parent is
+// shut down as well. It's safe to call Shutdown multiple times.
+func (m *Multicast) Shutdown(ctx context.Context) error {
+	m.closeOnce.Do(func() {
+		close(m.done)
+		if err := m.sendLeavingHeartbeat(); err != nil {
+			m.logger.Printf("send leaving heartbeat: %v", err)
+		}
+		if err := m.transport.Close(); err != nil {
+			m.closeErr = fmt.Errorf("close transport: %w", err)
+		}
+	})
+
+	stopped := make(chan struct{})
+	go func() {
+		m.goroutines.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	if err := m.outgoingConnection.Close(); err != nil {
-		return fmt.Errorf("close outgoing connection: %w", err)
+
+	if m.ownsParent {
+		m.parentOnce.Do(func() { m.parentErr = shutdownFader(ctx, m.parent) })
+		if m.parentErr != nil {
+			return errors.Join(m.closeErr, fmt.Errorf("shut down parent: %w", m.parentErr))
+		}
 	}
-	return nil
+	return m.closeErr
+}
+
+type shutdowner interface {
+	Shutdown(context.Context) error
 }
 
-func (m *Multicast) send(key []byte, time time.Time, value []byte) error {
-	mp := multicastPacket{key, time, value}
+// shutdownFader uses Shutdown, if the fader implements it, and Close otherwise.
+func shutdownFader(ctx context.Context, fader Fader) error {
+	if s, ok := fader.(shutdowner); ok {
+		return s.Shutdown(ctx)
+	}
+	return fader.Close()
+}
 
+// send marshals the packet and passes it to the write buffer. A packet that doesn't fit
+// into the write buffer is split into fragments. Once the leaving heartbeat has been sent,
+// ErrClosed is returned. It's safe for concurrent use.
+func (m *Multicast) send(mp *multicastPacket) error {
+	m.sendMutex.Lock()
+	defer m.sendMutex.Unlock()
+	if m.sendClosed {
+		return ErrClosed
+	}
+	return m.sendLocked(mp)
+}
+
+// sendLocked must be called with the send mutex being held.
+func (m *Multicast) sendLocked(mp *multicastPacket) error {
 	packet, err := mp.MarshalBinary()
 	if err != nil {
 		return fmt.Errorf("marshal packet: %w", err)
 	}
 
+	if len(packet) <= maximalWriteBufferSize {
+		return m.writePacket(packet)
+	}
+
+	fragments, err := fragment(m.messageID.Add(1), packet)
+	if err != nil {
+		return fmt.Errorf("fragment packet: %w", err)
+	}
+	for _, fragment := range fragments {
+		if err := m.writePacket(fragment); err != nil {
+			return err
+		}
+		m.counters.fragmentsSent.Add(1)
+	}
+	return nil
+}
+
+// writePacket places the packet in the write buffer. Without a flush interval, the buffer
+// is flushed right away. Otherwise, it's flushed once the next packet wouldn't fit into
+// it anymore or when the flush interval has passed. It must be called with the send mutex
+// being held.
+func (m *Multicast) writePacket(packet []byte) error {
+	if buffered := m.transmitter.Buffered(); buffered > 0 && buffered+len(packet) > maximalWriteBufferSize {
+		if err := m.flush(); err != nil {
+			return err
+		}
+	}
+
 	if _, err := m.transmitter.Write(packet); err != nil {
 		return fmt.Errorf("write packet: %w", err)
 	}
+	m.counters.packetsSent.Add(1)
+
+	flushInterval := time.Duration(m.flushInterval.Load())
+	if flushInterval <= 0 || m.transmitter.Buffered() >= maximalWriteBufferSize {
+		return m.flush()
+	}
+	if m.flushTimer == nil {
+		m.flushTimer = time.AfterFunc(flushInterval, m.flushPending)
+	}
+	return nil
+}
+
+// Flush sends all packets that are waiting in the write buffer.
+func (m *Multicast) Flush() error {
+	m.sendMutex.Lock()
+	defer m.sendMutex.Unlock()
+	if m.transmitter.Buffered() == 0 {
+		return nil
+	}
+	return m.flush()
+}
+
+func (m *Multicast) flushPending() {
+	if err := m.Flush(); err != nil {
+		m.logger.Printf("flush pending packets: %v", err)
+	}
+}
+
+// flush must be called with the send mutex being held.
+func (m *Multicast) flush() error {
+	if m.flushTimer != nil {
+		m.flushTimer.Stop()
+		m.flushTimer = nil
+	}
 
 	if err := m.transmitter.Flush(); err != nil {
 		return fmt.Errorf("flush: %w", err)
 	}
+	m.counters.datagramsSent.Add(1)
 
 	return nil
 }
 
 func (m *Multicast) receiveLoop() {
-	buffer := [2048]byte{}
-	mp := &multicastPacket{}
+	buffer := make([]byte, maximalDatagramSize)
+	reassembler := newReassembler(m.reassemblyTimeout, m.reassemblyMemory, &m.counters)
 	for {
-		n, err := m.transmitter.Read(buffer[:])
+		frame, n, err := m.transmitter.Read(buffer)
 		if err != nil {
-			if strings.Contains(err.Error(), "use of closed network connection") {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			select {
+			case <-m.done:
+				return
+			default:
+			}
+
+			receiveErr := &ReceiveError{Kind: ReceiveErrorTransport, Err: err}
+			errors.As(err, &receiveErr)
+			if !m.handleError(receiveErr) {
 				return
 			}
-			log.Printf("read error: %v", err)
 			continue
 		}
-		packet := buffer[:n]
+		frame.receivedAt = m.now()
+		m.counters.datagramsReceived.Add(1)
+		if !m.acceptFrame(frame) || !m.withinLimits(frame) {
+			continue
+		}
+
+		for datagram := buffer[:n]; len(datagram) > 0; {
+			mp := &multicastPacket{}
+			size, err := mp.decode(datagram)
+			if err != nil {
+				if errors.Is(err, ErrUnknownVersion) {
+					m.counters.unknownVersions.Add(1)
+				}
+				if !m.handleError(&ReceiveError{Kind: ReceiveErrorDecode, Sender: frame.sender, Err: err}) {
+					return
+				}
+				break
+			}
+			datagram = datagram[size:]
+			m.counters.packetsReceived.Add(1)
+
+			if err := m.receive(reassembler, frame, mp, size); err != nil {
+				if !m.handleError(err) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (m *Multicast) receive(reassembler *reassembler, frame *receivedFrame, mp *multicastPacket, size int) *ReceiveError {
+	sender := frame.sender
+	if mp.operation != operationHeartbeat {
+		m.peers.update(sender, m.now(), nil)
+	}
+
+	if mp.operation == operationFragment {
+		m.counters.fragmentsReceived.Add(1)
+		packet, err := reassembler.add(sender, mp, int(m.maximalItemSize.Load()), m.now())
+		if err != nil {
+			return &ReceiveError{Kind: ReceiveErrorDecode, Sender: sender, Err: fmt.Errorf("reassemble packet: %w", err)}
+		}
+		if packet == nil {
+			return nil
+		}
+		m.counters.reassembled.Add(1)
+		size = len(packet)
 
 		if err := mp.UnmarshalBinary(packet); err != nil {
-			log.Printf("unmarshal packet error: %v", err)
-			continue
+			return &ReceiveError{Kind: ReceiveErrorDecode, Sender: sender, Err: fmt.Errorf("unmarshal reassembled packet: %w", err)}
+		}
+	}
+
+	if !m.acceptPacket(sender, mp) {
+		return nil
+	}
+
+	if mp.channel != 0 {
+		return m.receiveChannel(frame, mp, size)
+	}
+
+	switch mp.operation {
+	case operationPut:
+		if m.stale(mp.time) {
+			m.counters.stalePuts.Add(1)
+			return nil
 		}
 
-		if m.itemReceivedHandler != nil && !m.itemReceivedHandler(mp.key, mp.time, mp.value) {
-			continue
+		if mp.flags&flagSync != 0 {
+			m.counters.syncItemsReceived.Add(1)
+			if m.contains(mp.key, mp.time, mp.value) {
+				m.counters.syncDuplicates.Add(1)
+				return nil
+			}
 		}
 
-		if err := m.parent.Put(mp.key, mp.time, mp.value); err != nil {
-			log.Printf("put into parent fader: %v", err)
-			return
+		if !m.withinPutLimit() {
+			return nil
 		}
+
+		item := newReceivedItem(frame, mp, size)
+		if m.itemHandler != nil && !m.itemHandler(item) {
+			return nil
+		}
+
+		put, err := m.putUnlessStale(item.Key, item.Time, item.Value)
+		if !put {
+			m.counters.stalePuts.Add(1)
+			return nil
+		}
+		if err != nil {
+			return &ReceiveError{Kind: ReceiveErrorParent, Sender: sender, Err: fmt.Errorf("put into parent fader: %w", err)}
+		}
+	case operationClear:
+		m.handleClear(mp)
+	case operationHeartbeat:
+		m.handleHeartbeat(sender, mp)
+	case operationSyncRequest:
+		m.handleSyncRequest()
+	case operationDigest:
+		if err := m.handleDigest(sender, mp); err != nil {
+			return &ReceiveError{Kind: ReceiveErrorDecode, Sender: sender, Err: err}
+		}
+	case operationRangeRequest:
+		m.handleRangeRequest(mp)
+	default:
+		m.counters.unhandledOperations.Add(1)
 	}
+	return nil
 }
@@ -0,0 +1,174 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// AccessPolicy restricts the nodes, whose packets are accepted. Everyone who holds the
+// key can send packets, so the policy only helps against nodes, that don't fake their
+// id or source address.
+//
+// Items, that are sent in response to sync or range requests, are relayed by the
+// responding node under its own id. They are checked against the permissions of the
+// responder, not the ones of the node that originally put them. A responder with
+// restricted prefixes can only relay items of these prefixes.
+type AccessPolicy struct {
+	// Senders contains the ids of the nodes, that are allowed to send. If it's empty,
+	// all ids are allowed.
+	Senders [][]byte
+	// Networks contains the networks, that packets are accepted from. If it's empty, all
+	// sources are allowed. Otherwise, frames from sources without an ip address, like
+	// the ones of a Bus, are rejected.
+	Networks []netip.Prefix
+	// Permissions restricts the keys, that single nodes are allowed to write.
+	Permissions []PeerPermission
+}
+
+// PeerPermission restricts the node with the given id to put items, whose key starts
+// with one of the prefixes. The node isn't allowed to clear the group.
+type PeerPermission struct {
+	Sender   []byte
+	Prefixes [][]byte
+}
+
+type accessPolicy struct {
+	senders     map[string]bool
+	networks    []netip.Prefix
+	permissions map[string][][]byte
+}
+
+func newAccessPolicy(policy AccessPolicy) (*accessPolicy, error) {
+	a := &accessPolicy{
+		senders:     make(map[string]bool),
+		permissions: make(map[string][][]byte),
+	}
+	for _, sender := range policy.Senders {
+		if len(sender) != idSize {
+			return nil, fmt.Errorf("sender id of length %d: %w", len(sender), ErrInvalidOption)
+		}
+		a.senders[string(sender)] = true
+	}
+	for _, network := range policy.Networks {
+		if !network.IsValid() {
+			return nil, fmt.Errorf("network %s: %w", network, ErrInvalidOption)
+		}
+		a.networks = append(a.networks, network.Masked())
+	}
+	for _, permission := range policy.Permissions {
+		if len(permission.Sender) != idSize {
+			return nil, fmt.Errorf("permission sender id of length %d: %w", len(permission.Sender), ErrInvalidOption)
+		}
+		a.permissions[string(permission.Sender)] = append(a.permissions[string(permission.Sender)], permission.Prefixes...)
+	}
+	return a, nil
+}
+
+// SetAccessPolicy sets the policy, that is enforced on every received frame. Rejected
+// frames and items are counted in the stats. An empty policy accepts everything.
+func (m *Multicast) SetAccessPolicy(policy AccessPolicy) error {
+	a, err := newAccessPolicy(policy)
+	if err != nil {
+		return err
+	}
+	m.accessPolicy.Store(a)
+	return nil
+}
+
+// WithAccessPolicy sets the policy, that is enforced on every received frame. See
+// SetAccessPolicy.
+func WithAccessPolicy(policy AccessPolicy) MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		a, err := newAccessPolicy(policy)
+		if err != nil {
+			return err
+		}
+		o.settings = append(o.settings, func(m *Multicast) { m.accessPolicy.Store(a) })
+		return nil
+	})
+}
+
+// acceptFrame returns true, if the sender and the source of the frame are allowed.
+func (m *Multicast) acceptFrame(frame *receivedFrame) bool {
+	a := m.accessPolicy.Load()
+	if a == nil {
+		return true
+	}
+
+	if len(a.senders) > 0 && !a.senders[string(frame.sender)] {
+		m.counters.rejectedSenders.Add(1)
+		return false
+	}
+
+	if len(a.networks) > 0 && !a.allowedSource(frame.source) {
+		m.counters.rejectedSources.Add(1)
+		return false
+	}
+
+	return true
+}
+
+func (a *accessPolicy) allowedSource(source net.Addr) bool {
+	var address netip.Addr
+	switch source := source.(type) {
+	case *net.UDPAddr:
+		address = source.AddrPort().Addr()
+	case *net.TCPAddr:
+		address = source.AddrPort().Addr()
+	default:
+		return false
+	}
+	address = address.Unmap()
+
+	for _, network := range a.networks {
+		if network.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptPacket returns true, if the sender is allowed to perform the operation of the
+// packet.
+func (m *Multicast) acceptPacket(sender []byte, mp *multicastPacket) bool {
+	a := m.accessPolicy.Load()
+	if a == nil {
+		return true
+	}
+
+	prefixes, found := a.permissions[string(sender)]
+	if !found {
+		return true
+	}
+
+	switch mp.operation {
+	case operationPut:
+		for _, prefix := range prefixes {
+			if bytes.HasPrefix(mp.key, prefix) {
+				return true
+			}
+		}
+	case operationClear:
+	default:
+		return true
+	}
+
+	m.counters.rejectedPackets.Add(1)
+	return false
+}
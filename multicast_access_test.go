@@ -0,0 +1,144 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package fader_test
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/posteo/fader"
+)
+
+func TestMulticastAccessPolicy(t *testing.T) {
+	t.Run("Senders", func(t *testing.T) {
+		bus := fader.NewBus()
+		receiver, parent := setUpBusFader(t, bus, "receiver", multicastFaderIDOne, fader.WithAccessPolicy(fader.AccessPolicy{
+			Senders: [][]byte{multicastFaderIDTwo},
+		}))
+		two, _ := setUpBusFader(t, bus, "two", multicastFaderIDTwo)
+		three, _ := setUpBusFader(t, bus, "three", multicastFaderIDThree)
+
+		require.NoError(t, two.Put([]byte("two"), time.Now(), []byte("value")))
+		require.NoError(t, three.Put([]byte("three"), time.Now(), []byte("value")))
+		time.Sleep(10 * time.Millisecond)
+
+		_, value := parent.Get([]byte("two"))
+		assert.Equal(t, []byte("value"), value)
+		_, value = parent.Get([]byte("three"))
+		assert.Nil(t, value)
+		assert.Equal(t, uint64(1), receiver.Stats().RejectedSenders)
+	})
+
+	t.Run("Networks", func(t *testing.T) {
+		bus := fader.NewBus()
+		receiver, parent := setUpBusFader(t, bus, "receiver", multicastFaderIDOne, fader.WithAccessPolicy(fader.AccessPolicy{
+			Networks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		}))
+		two, _ := setUpBusFader(t, bus, "two", multicastFaderIDTwo)
+
+		require.NoError(t, two.Put([]byte("key"), time.Now(), []byte("value")))
+		time.Sleep(10 * time.Millisecond)
+
+		assert.Equal(t, 0, parent.Size())
+		assert.Equal(t, uint64(1), receiver.Stats().RejectedSources)
+
+		require.NoError(t, receiver.SetAccessPolicy(fader.AccessPolicy{}))
+		three, _ := setUpBusFader(t, bus, "three", multicastFaderIDThree)
+		require.NoError(t, three.Put([]byte("key"), time.Now(), []byte("value")))
+		time.Sleep(10 * time.Millisecond)
+
+		assert.Equal(t, 1, parent.Size())
+	})
+
+	t.Run("LoopbackNetwork", func(t *testing.T) {
+		transport := func(port int) fader.Transport {
+			transport, err := fader.NewUnicastTransport(fmt.Sprintf("127.0.0.1:%d", port), []string{"127.0.0.1:2302"})
+			require.NoError(t, err)
+			return transport
+		}
+		receiver, parent := setUpTransportFader(t, transport(2302), multicastFaderIDOne, fader.WithAccessPolicy(fader.AccessPolicy{
+			Networks: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+		}))
+		two, _ := setUpTransportFader(t, transport(2303), multicastFaderIDTwo)
+
+		require.NoError(t, two.Put([]byte("key"), time.Now(), []byte("value")))
+		time.Sleep(10 * time.Millisecond)
+
+		assert.Equal(t, 1, parent.Size())
+		assert.Equal(t, uint64(0), receiver.Stats().RejectedSources)
+	})
+
+	t.Run("Permissions", func(t *testing.T) {
+		bus := fader.NewBus()
+		receiver, parent := setUpBusFader(t, bus, "receiver", multicastFaderIDOne, fader.WithAccessPolicy(fader.AccessPolicy{
+			Permissions: []fader.PeerPermission{
+				{Sender: multicastFaderIDTwo, Prefixes: [][]byte{[]byte("two:")}},
+			},
+		}))
+		two, _ := setUpBusFader(t, bus, "two", multicastFaderIDTwo)
+		three, _ := setUpBusFader(t, bus, "three", multicastFaderIDThree)
+
+		require.NoError(t, two.Put([]byte("two:key"), time.Now(), []byte("value")))
+		require.NoError(t, two.Put([]byte("other:key"), time.Now(), []byte("value")))
+		require.NoError(t, two.ClearAll())
+		time.Sleep(10 * time.Millisecond)
+
+		_, value := parent.Get([]byte("two:key"))
+		assert.Equal(t, []byte("value"), value)
+		_, value = parent.Get([]byte("other:key"))
+		assert.Nil(t, value)
+		assert.Equal(t, uint64(2), receiver.Stats().RejectedPackets)
+
+		require.NoError(t, three.Put([]byte("other:key"), time.Now(), []byte("value")))
+		time.Sleep(10 * time.Millisecond)
+
+		assert.Equal(t, 2, parent.Size())
+	})
+
+	t.Run("Sync", func(t *testing.T) {
+		bus := fader.NewBus()
+		_, parentTwo := setUpBusFader(t, bus, "two", multicastFaderIDTwo)
+		require.NoError(t, parentTwo.Put([]byte("session:key"), time.Now(), []byte("value")))
+		require.NoError(t, parentTwo.Put([]byte("other"), time.Now(), []byte("value")))
+
+		// The items are relayed under the responder's id, so its permissions apply.
+		receiver, parent := setUpBusFader(t, bus, "receiver", multicastFaderIDOne,
+			fader.WithAccessPolicy(fader.AccessPolicy{
+				Permissions: []fader.PeerPermission{
+					{Sender: multicastFaderIDTwo, Prefixes: [][]byte{[]byte("session:")}},
+				},
+			}),
+			fader.WithSyncOnJoin())
+		time.Sleep(100 * time.Millisecond)
+
+		_, value := parent.Get([]byte("session:key"))
+		assert.Equal(t, []byte("value"), value)
+		_, value = parent.Get([]byte("other"))
+		assert.Nil(t, value)
+		assert.Equal(t, uint64(1), receiver.Stats().RejectedPackets)
+	})
+
+	t.Run("InvalidSender", func(t *testing.T) {
+		receiver, _ := setUpBusFader(t, fader.NewBus(), "receiver", multicastFaderIDOne)
+
+		err := receiver.SetAccessPolicy(fader.AccessPolicy{Senders: [][]byte{{1}}})
+		assert.True(t, errors.Is(err, fader.ErrInvalidOption))
+	})
+}
@@ -0,0 +1,256 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"time"
+)
+
+// Anti-entropy repairs the divergence caused by lost packets. Each node periodically
+// publishes a digest of its items, that are grouped into buckets by time. A node that
+// receives a digest compares it with its own and requests the items of all differing
+// buckets from the sender of the digest. Items that the receiver has but the sender is
+// missing, are repaired once the receiver publishes its own digest.
+const (
+	digestBucketWidth        = time.Second
+	digestEntrySize          = 8 + 4 + 8
+	maximalDigestEntries     = 1024
+	defaultAntiEntropyRate   = 1000
+	antiEntropyIdleInterval  = time.Minute
+	antiEntropyMaximalJitter = 0.1
+)
+
+type digestEntry struct {
+	bucket int64
+	count  uint32
+	hash   uint64
+}
+
+type digest map[int64]digestEntry
+
+// SetAntiEntropyInterval sets the interval in which the digest of the items is published.
+// The default of zero disables anti-entropy. The parent fader must implement Ranger.
+func (m *Multicast) SetAntiEntropyInterval(interval time.Duration) {
+	m.antiEntropyInterval.Store(int64(interval))
+	select {
+	case m.antiEntropyReset <- struct{}{}:
+	default:
+	}
+}
+
+// SetAntiEntropyRate sets the number of items per second that are sent in response to
+// a range request. The default is 1000.
+func (m *Multicast) SetAntiEntropyRate(rate int) {
+	m.antiEntropyRate.Store(int64(rate))
+}
+
+func (m *Multicast) antiEntropyLoop() {
+	for {
+		interval := time.Duration(m.antiEntropyInterval.Load())
+		wait := antiEntropyIdleInterval
+		if interval > 0 {
+			wait = interval + time.Duration(rand.Float64()*antiEntropyMaximalJitter*float64(interval))
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-m.antiEntropyReset:
+			continue
+		case <-m.done:
+			return
+		}
+
+		if interval <= 0 {
+			continue
+		}
+		if err := m.sendDigest(); err != nil && !errors.Is(err, ErrClosed) {
+			m.logger.Printf("send digest: %v", err)
+		}
+	}
+}
+
+func (m *Multicast) sendDigest() error {
+	ranger, ok := m.parent.(Ranger)
+	if !ok {
+		return nil
+	}
+
+	d := computeDigest(ranger, m.now())
+	if len(d) == 0 {
+		return nil
+	}
+
+	if err := m.send(&multicastPacket{operation: operationDigest, time: m.now(), value: d.encode()}); err != nil {
+		return err
+	}
+	m.counters.digestsSent.Add(1)
+
+	return m.Flush()
+}
+
+// handleDigest requests the items of all buckets, that differ from the received digest.
+// Only an error in decoding the digest is returned.
+func (m *Multicast) handleDigest(sender []byte, mp *multicastPacket) error {
+	m.counters.digestsReceived.Add(1)
+
+	ranger, ok := m.parent.(Ranger)
+	if !ok {
+		return nil
+	}
+
+	remote, err := decodeDigest(mp.value)
+	if err != nil {
+		return fmt.Errorf("decode digest: %w", err)
+	}
+	local := computeDigest(ranger, m.now())
+
+	buckets := []int64{}
+	for bucket, remoteEntry := range remote {
+		if local[bucket] != remoteEntry {
+			buckets = append(buckets, bucket)
+		}
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+
+	value := make([]byte, 0, 8*len(buckets))
+	for _, bucket := range buckets {
+		value = binary.BigEndian.AppendUint64(value, uint64(bucket))
+	}
+
+	if err := m.send(&multicastPacket{operation: operationRangeRequest, key: sender, time: m.now(), value: value}); err != nil {
+		if !errors.Is(err, ErrClosed) {
+			m.logger.Printf("send range request: %v", err)
+		}
+		return nil
+	}
+	m.counters.rangeRequestsSent.Add(1)
+
+	if err := m.Flush(); err != nil {
+		m.logger.Printf("flush range request: %v", err)
+	}
+	return nil
+}
+
+// handleRangeRequest responds to a request that is addressed to this node with the items
+// of the requested buckets. While a response is sent, further requests are ignored.
+func (m *Multicast) handleRangeRequest(mp *multicastPacket) {
+	if !bytes.Equal(mp.key, m.transmitter.id) {
+		return
+	}
+	m.counters.rangeRequestsReceived.Add(1)
+
+	ranger, ok := m.parent.(Ranger)
+	if !ok || len(mp.value)%8 != 0 || !m.antiEntropyResponding.CompareAndSwap(false, true) {
+		m.counters.rangeRequestsIgnored.Add(1)
+		return
+	}
+
+	buckets := make(map[int64]struct{})
+	for index := 0; index < len(mp.value); index += 8 {
+		buckets[int64(binary.BigEndian.Uint64(mp.value[index:index+8]))] = struct{}{}
+	}
+
+	m.goroutines.Go(func() {
+		defer m.antiEntropyResponding.Store(false)
+
+		items := []item{}
+		ranger.Range(func(key []byte, t time.Time, value []byte) bool {
+			if _, found := buckets[digestBucket(t)]; found {
+				items = append(items, item{key: key, time: t, value: value})
+			}
+			return true
+		})
+
+		sent := m.sendItems(items, int(m.antiEntropyRate.Load()))
+		m.counters.rangeItemsSent.Add(uint64(sent))
+	})
+}
+
+func digestBucket(t time.Time) int64 {
+	return t.UnixNano() / int64(digestBucketWidth)
+}
+
+// computeDigest sums up the hashes of all items per bucket. The sum doesn't depend on the
+// order of the items and, unlike a xor, keeps duplicates from cancelling out each other.
+// The most recent bucket is left out, since its items might still be in flight.
+func computeDigest(ranger Ranger, now time.Time) digest {
+	latestBucket := digestBucket(now.Add(-digestBucketWidth))
+
+	d := make(digest)
+	ranger.Range(func(key []byte, t time.Time, value []byte) bool {
+		bucket := digestBucket(t)
+		if bucket > latestBucket {
+			return true
+		}
+
+		hash := fnv.New64a()
+		hash.Write(key)
+		binary.Write(hash, binary.BigEndian, t.UnixNano())
+		hash.Write(value)
+
+		entry := d[bucket]
+		entry.bucket = bucket
+		entry.count++
+		entry.hash += hash.Sum64()
+		d[bucket] = entry
+		return true
+	})
+
+	for len(d) > maximalDigestEntries {
+		oldest := latestBucket
+		for bucket := range d {
+			if bucket < oldest {
+				oldest = bucket
+			}
+		}
+		delete(d, oldest)
+	}
+	return d
+}
+
+func (d digest) encode() []byte {
+	buffer := make([]byte, 0, len(d)*digestEntrySize)
+	for _, entry := range d {
+		buffer = binary.BigEndian.AppendUint64(buffer, uint64(entry.bucket))
+		buffer = binary.BigEndian.AppendUint32(buffer, entry.count)
+		buffer = binary.BigEndian.AppendUint64(buffer, entry.hash)
+	}
+	return buffer
+}
+
+func decodeDigest(buffer []byte) (digest, error) {
+	if len(buffer)%digestEntrySize != 0 {
+		return nil, fmt.Errorf("digest of size %d: %w", len(buffer), ErrMalformedPacket)
+	}
+
+	d := make(digest)
+	for index := 0; index < len(buffer); index += digestEntrySize {
+		entry := digestEntry{
+			bucket: int64(binary.BigEndian.Uint64(buffer[index : index+8])),
+			count:  binary.BigEndian.Uint32(buffer[index+8 : index+12]),
+			hash:   binary.BigEndian.Uint64(buffer[index+12 : index+20]),
+		}
+		d[entry.bucket] = entry
+	}
+	return d, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+const busQueueSize = 1024
+
+// ErrAddressInUse is returned if a second transport is attached to a bus with an address,
+// that is already in use.
+var ErrAddressInUse = errors.New("address in use")
+
+// Bus connects transports within a single process. Every frame that is sent by one of
+// its transports is delivered to all others. Like udp, the bus drops frames if the queue
+// of a receiving transport is full. It allows to run a group of faders in tests without
+// a network that supports multicast.
+type Bus struct {
+	mutex      sync.RWMutex
+	transports map[string]*busTransport
+}
+
+// NewBus returns an empty bus.
+func NewBus() *Bus {
+	return &Bus{
+		transports: make(map[string]*busTransport),
+	}
+}
+
+// Transport attaches a new transport with the given address to the bus. The address
+// identifies the transport as source of its frames.
+func (b *Bus) Transport(address string) (Transport, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, found := b.transports[address]; found {
+		return nil, fmt.Errorf("bus address [%s]: %w", address, ErrAddressInUse)
+	}
+
+	t := &busTransport{
+		bus:      b,
+		address:  BusAddr(address),
+		incoming: make(chan busFrame, busQueueSize),
+		done:     make(chan struct{}),
+	}
+	b.transports[address] = t
+	return t, nil
+}
+
+func (b *Bus) deliver(source *busTransport, frame []byte) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for _, t := range b.transports {
+		if t == source {
+			continue
+		}
+		select {
+		case t.incoming <- busFrame{source: source.address, data: append([]byte{}, frame...)}:
+		default:
+		}
+	}
+}
+
+func (b *Bus) detach(t *busTransport) {
+	b.mutex.Lock()
+	delete(b.transports, string(t.address))
+	b.mutex.Unlock()
+}
+
+// BusAddr is the address of a transport on a bus.
+type BusAddr string
+
+// Network returns "bus".
+func (a BusAddr) Network() string {
+	return "bus"
+}
+
+func (a BusAddr) String() string {
+	return string(a)
+}
+
+type busFrame struct {
+	source BusAddr
+	data   []byte
+}
+
+type busTransport struct {
+	bus       *Bus
+	address   BusAddr
+	incoming  chan busFrame
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (t *busTransport) Send(frame []byte) error {
+	select {
+	case <-t.done:
+		return fmt.Errorf("send frame: %w", net.ErrClosed)
+	default:
+	}
+	t.bus.deliver(t, frame)
+	return nil
+}
+
+func (t *busTransport) Receive(buffer []byte) (int, net.Addr, error) {
+	select {
+	case frame := <-t.incoming:
+		return copy(buffer, frame.data), frame.source, nil
+	case <-t.done:
+		return 0, nil, fmt.Errorf("receive frame: %w", net.ErrClosed)
+	}
+}
+
+func (t *busTransport) Close() error {
+	t.closeOnce.Do(func() {
+		t.bus.detach(t)
+		close(t.done)
+	})
+	return nil
+}
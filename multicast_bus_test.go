@@ -0,0 +1,104 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/posteo/fader"
+	"github.com/posteo/fader/crypt"
+)
+
+func TestBus(t *testing.T) {
+	bus := fader.NewBus()
+	one, err := bus.Transport("one")
+	require.NoError(t, err)
+	two, err := bus.Transport("two")
+	require.NoError(t, err)
+	three, err := bus.Transport("three")
+	require.NoError(t, err)
+
+	t.Run("AddressInUse", func(t *testing.T) {
+		_, err := bus.Transport("one")
+		assert.True(t, errors.Is(err, fader.ErrAddressInUse))
+	})
+
+	t.Run("Delivery", func(t *testing.T) {
+		require.NoError(t, one.Send([]byte("frame")))
+
+		for _, transport := range []fader.Transport{two, three} {
+			buffer := make([]byte, 16)
+			n, source, err := transport.Receive(buffer)
+			require.NoError(t, err)
+			assert.Equal(t, "frame", string(buffer[:n]))
+			assert.Equal(t, "one", source.String())
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		require.NoError(t, three.Close())
+
+		_, _, err := three.Receive(make([]byte, 16))
+		assert.True(t, errors.Is(err, net.ErrClosed))
+		assert.True(t, errors.Is(three.Send([]byte("frame")), net.ErrClosed))
+	})
+}
+
+func TestMulticastOverBus(t *testing.T) {
+	bus := fader.NewBus()
+	keyring, err := crypt.NewKeyring(0, multicastKey)
+	require.NoError(t, err)
+
+	faders := []*fader.Multicast{}
+	for index, id := range [][]byte{multicastFaderIDOne, multicastFaderIDTwo} {
+		transport, err := bus.Transport(string(rune('a' + index)))
+		require.NoError(t, err)
+
+		memoryFader := fader.NewMemory(time.Second)
+		defer memoryFader.Close()
+		multicastFader, err := fader.NewMulticastWithTransport(memoryFader, transport, keyring, id, nil)
+		require.NoError(t, err)
+		defer multicastFader.Close()
+		faders = append(faders, multicastFader)
+	}
+
+	require.NoError(t, faders[0].Put([]byte("key"), time.Now(), []byte("value")))
+	time.Sleep(10 * time.Millisecond)
+
+	_, value := faders[1].Get([]byte("key"))
+	assert.Equal(t, "value", string(value))
+}
+
+func TestMulticastWithTransportOfInvalidArguments(t *testing.T) {
+	bus := fader.NewBus()
+	transport, err := bus.Transport("a")
+	require.NoError(t, err)
+	keyring, err := crypt.NewKeyring(0, multicastKey)
+	require.NoError(t, err)
+	memoryFader := fader.NewMemory(time.Second)
+	defer memoryFader.Close()
+
+	_, err = fader.NewMulticastWithTransport(memoryFader, nil, keyring, nil, nil)
+	assert.True(t, errors.Is(err, fader.ErrInvalidOption))
+
+	_, err = fader.NewMulticastWithTransport(memoryFader, transport, nil, nil, nil)
+	assert.True(t, errors.Is(err, fader.ErrInvalidOption))
+}
@@ -0,0 +1,226 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrChannelExists is returned if a channel is opened, whose name or id is already in use.
+// Since the id is a 32 bit hash of the name, two names may collide.
+var ErrChannelExists = errors.New("channel exists")
+
+// Channel is a fader, whose items are replicated over the connection of a multicast
+// fader, but separately from the items of the multicast fader and of other channels.
+// The packets of a channel carry an id, that is derived from the channel's name, and
+// are put into the parent fader of the channel with the same name on each node. Items
+// of channels, that aren't open on a node, are dropped there.
+//
+// Clears, state transfers and anti-entropy only cover the items of the multicast fader.
+type Channel struct {
+	multicast   *Multicast
+	name        string
+	id          uint32
+	parent      Fader
+	itemHandler ItemHandler
+	counters    channelCounters
+}
+
+// ChannelStats contains the counters of a channel.
+type ChannelStats struct {
+	PacketsSent     uint64
+	PacketsReceived uint64
+	ParentErrors    uint64
+}
+
+type channelCounters struct {
+	packetsSent     atomic.Uint64
+	packetsReceived atomic.Uint64
+	parentErrors    atomic.Uint64
+}
+
+type channelTable struct {
+	mutex    sync.RWMutex
+	channels map[uint32]*Channel
+}
+
+func newChannelTable() *channelTable {
+	return &channelTable{
+		channels: make(map[uint32]*Channel),
+	}
+}
+
+func (t *channelTable) get(id uint32) *Channel {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.channels[id]
+}
+
+// Channel opens a channel with the given name, whose items are stored in the parent
+// fader. The handler is called like the one of WithItemHandler for every item, that is
+// received on the channel, and may be nil. The name must be the same on all nodes.
+func (m *Multicast) Channel(name string, parent Fader, itemHandler ItemHandler) (*Channel, error) {
+	if name == "" {
+		return nil, fmt.Errorf("empty channel name: %w", ErrInvalidOption)
+	}
+	if parent == nil {
+		return nil, fmt.Errorf("nil channel parent: %w", ErrInvalidOption)
+	}
+
+	c := &Channel{
+		multicast:   m,
+		name:        name,
+		id:          channelID(name),
+		parent:      parent,
+		itemHandler: itemHandler,
+	}
+
+	m.channels.mutex.Lock()
+	defer m.channels.mutex.Unlock()
+
+	if existing, found := m.channels.channels[c.id]; found {
+		if existing.name != name {
+			return nil, fmt.Errorf("channel %s collides with channel %s on id %08x: %w", name, existing.name, c.id, ErrChannelExists)
+		}
+		return nil, fmt.Errorf("channel %s: %w", name, ErrChannelExists)
+	}
+	if c.id == 0 {
+		return nil, fmt.Errorf("channel %s collides with the default channel on id 0: %w", name, ErrChannelExists)
+	}
+	m.channels.channels[c.id] = c
+	return c, nil
+}
+
+func channelID(name string) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	return hash.Sum32()
+}
+
+// Name returns the name of the channel.
+func (c *Channel) Name() string {
+	return c.name
+}
+
+// Put places an item in the parent fader of the channel and sends it to the channel of
+// the other nodes. After the multicast fader has been shut down, ErrClosed is returned.
+// Since ClearAll doesn't cover channels, items are not checked against the latest clear
+// here nor on the receiving nodes.
+func (c *Channel) Put(key []byte, time time.Time, value []byte) error {
+	m := c.multicast
+	select {
+	case <-m.done:
+		return ErrClosed
+	default:
+	}
+	if size := len(key) + len(value); int64(size) > m.maximalItemSize.Load() {
+		return fmt.Errorf("item of size %d: %w", size, ErrItemTooLarge)
+	}
+	if err := m.send(&multicastPacket{operation: operationPut, channel: c.id, key: key, time: time, value: value}); err != nil {
+		return fmt.Errorf("send item: %w", err)
+	}
+	c.counters.packetsSent.Add(1)
+	return c.parent.Put(key, time, value)
+}
+
+// Range calls the given function for each item in the parent fader, if it implements Ranger.
+func (c *Channel) Range(f func([]byte, time.Time, []byte) bool) {
+	if ranger, ok := c.parent.(Ranger); ok {
+		ranger.Range(f)
+	}
+}
+
+// Get returns time and value for the provided key. If no such key exists, a value
+// of nil is returned.
+func (c *Channel) Get(key []byte) (time.Time, []byte) {
+	return c.parent.Get(key)
+}
+
+// Earliest returns key, time and value of the earliest item in the channel.
+func (c *Channel) Earliest() ([]byte, time.Time, []byte) {
+	return c.parent.Earliest()
+}
+
+// Select returns all times and values with the provided key.
+func (c *Channel) Select(key []byte) ([]time.Time, [][]byte) {
+	return c.parent.Select(key)
+}
+
+// Size returns the number of items in the channel.
+func (c *Channel) Size() int {
+	return c.parent.Size()
+}
+
+// Clear performs a clear on the parent fader of the channel only.
+func (c *Channel) Clear() {
+	c.parent.Clear()
+}
+
+// Close closes the channel, so that its items are no longer received. The parent fader
+// has to be closed by the caller.
+func (c *Channel) Close() error {
+	channels := c.multicast.channels
+	channels.mutex.Lock()
+	if channels.channels[c.id] == c {
+		delete(channels.channels, c.id)
+	}
+	channels.mutex.Unlock()
+	return nil
+}
+
+// Stats returns the counters of the channel.
+func (c *Channel) Stats() ChannelStats {
+	return ChannelStats{
+		PacketsSent:     c.counters.packetsSent.Load(),
+		PacketsReceived: c.counters.packetsReceived.Load(),
+		ParentErrors:    c.counters.parentErrors.Load(),
+	}
+}
+
+// receiveChannel puts a received item into the parent fader of its channel.
+func (m *Multicast) receiveChannel(frame *receivedFrame, mp *multicastPacket, size int) *ReceiveError {
+	if mp.operation != operationPut {
+		m.counters.unhandledOperations.Add(1)
+		return nil
+	}
+
+	c := m.channels.get(mp.channel)
+	if c == nil {
+		m.counters.unknownChannels.Add(1)
+		return nil
+	}
+	c.counters.packetsReceived.Add(1)
+
+	if !m.withinPutLimit() {
+		return nil
+	}
+
+	item := newReceivedItem(frame, mp, size)
+	if c.itemHandler != nil && !c.itemHandler(item) {
+		return nil
+	}
+
+	if err := c.parent.Put(item.Key, item.Time, item.Value); err != nil {
+		c.counters.parentErrors.Add(1)
+		err = fmt.Errorf("put into parent fader of channel %s: %w", c.name, err)
+		return &ReceiveError{Kind: ReceiveErrorParent, Sender: frame.sender, Channel: c.name, Err: err}
+	}
+	return nil
+}
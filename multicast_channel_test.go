@@ -0,0 +1,152 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package fader_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/posteo/fader"
+)
+
+func TestMulticastChannels(t *testing.T) {
+	bus := fader.NewBus()
+	newParent := func() fader.Fader {
+		parent := fader.NewMemory(time.Minute)
+		t.Cleanup(func() { parent.Close() })
+		return parent
+	}
+
+	one, _ := setUpBusFader(t, bus, "one", multicastFaderIDOne)
+	two, parentTwo := setUpBusFader(t, bus, "two", multicastFaderIDTwo)
+
+	sessionsOne, err := one.Channel("sessions", newParent(), nil)
+	require.NoError(t, err)
+	greylistOne, err := one.Channel("greylist", newParent(), nil)
+	require.NoError(t, err)
+
+	items := make(chan fader.ReceivedItem, 10)
+	sessionsParentTwo := newParent()
+	sessionsTwo, err := two.Channel("sessions", sessionsParentTwo, func(item *fader.ReceivedItem) bool {
+		items <- *item
+		return true
+	})
+	require.NoError(t, err)
+
+	t.Run("Default", func(t *testing.T) {
+		require.NoError(t, one.Put([]byte("default"), time.Now(), []byte("value")))
+		time.Sleep(10 * time.Millisecond)
+
+		_, value := parentTwo.Get([]byte("default"))
+		assert.Equal(t, []byte("value"), value)
+		assert.Equal(t, 0, sessionsTwo.Size())
+	})
+
+	t.Run("Routing", func(t *testing.T) {
+		require.NoError(t, sessionsOne.Put([]byte("session"), time.Now(), []byte("value")))
+		time.Sleep(10 * time.Millisecond)
+
+		_, value := sessionsTwo.Get([]byte("session"))
+		assert.Equal(t, []byte("value"), value)
+		_, value = parentTwo.Get([]byte("session"))
+		assert.Nil(t, value)
+
+		item := <-items
+		assert.Equal(t, multicastFaderIDOne, item.Sender)
+		assert.Equal(t, uint64(1), sessionsOne.Stats().PacketsSent)
+		assert.Equal(t, uint64(1), sessionsTwo.Stats().PacketsReceived)
+	})
+
+	t.Run("Fragments", func(t *testing.T) {
+		value := bytes.Repeat([]byte("x"), 2000)
+		require.NoError(t, sessionsOne.Put([]byte("large"), time.Now(), value))
+		time.Sleep(10 * time.Millisecond)
+
+		_, received := sessionsParentTwo.Get([]byte("large"))
+		assert.Equal(t, value, received)
+		<-items
+	})
+
+	t.Run("UnknownChannel", func(t *testing.T) {
+		require.NoError(t, greylistOne.Put([]byte("greylist"), time.Now(), []byte("value")))
+		time.Sleep(10 * time.Millisecond)
+
+		assert.Equal(t, uint64(1), two.Stats().UnknownChannels)
+		_, value := parentTwo.Get([]byte("greylist"))
+		assert.Nil(t, value)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		require.NoError(t, sessionsTwo.Close())
+		require.NoError(t, sessionsOne.Put([]byte("closed"), time.Now(), []byte("value")))
+		time.Sleep(10 * time.Millisecond)
+
+		assert.Equal(t, uint64(2), two.Stats().UnknownChannels)
+		_, value := sessionsParentTwo.Get([]byte("closed"))
+		assert.Nil(t, value)
+	})
+
+	t.Run("Exists", func(t *testing.T) {
+		_, err := one.Channel("sessions", newParent(), nil)
+		assert.True(t, errors.Is(err, fader.ErrChannelExists))
+		_, err = one.Channel("", newParent(), nil)
+		assert.True(t, errors.Is(err, fader.ErrInvalidOption))
+
+		// "costarring" and "liquid" share their FNV-1a hash.
+		_, err = one.Channel("costarring", newParent(), nil)
+		require.NoError(t, err)
+		_, err = one.Channel("liquid", newParent(), nil)
+		assert.True(t, errors.Is(err, fader.ErrChannelExists))
+		assert.True(t, strings.Contains(err.Error(), "collides with channel costarring"), err.Error())
+	})
+}
+
+func TestMulticastChannelPutLimit(t *testing.T) {
+	bus := fader.NewBus()
+	now := time.Now()
+	one, _ := setUpBusFader(t, bus, "one", multicastFaderIDOne)
+	two, _ := setUpBusFader(t, bus, "two", multicastFaderIDTwo,
+		fader.WithClock(func() time.Time { return now }),
+		fader.WithRateLimits(fader.RateLimits{PutRate: 1, PutBurst: 2}))
+
+	parentOne, parentTwo := fader.NewMemory(time.Minute), fader.NewMemory(time.Minute)
+	defer parentOne.Close()
+	defer parentTwo.Close()
+
+	sessionsOne, err := one.Channel("sessions", parentOne, nil)
+	require.NoError(t, err)
+	handled := atomic.Int64{}
+	sessionsTwo, err := two.Channel("sessions", parentTwo, func(*fader.ReceivedItem) bool {
+		handled.Add(1)
+		return true
+	})
+	require.NoError(t, err)
+
+	for index := range 5 {
+		require.NoError(t, sessionsOne.Put([]byte{byte(index)}, time.Now(), []byte("value")))
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	assert.Equal(t, 2, sessionsTwo.Size())
+	assert.Equal(t, uint64(3), two.Stats().PutsLimited)
+	// The handler isn't called for limited items.
+	assert.Equal(t, int64(2), handled.Load())
+}
@@ -0,0 +1,117 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrStaleItem is returned if an item is put, that is not newer than the latest clear of
+// the group.
+var ErrStaleItem = errors.New("item older than the latest clear")
+
+// ClearAll clears the parent fader of every node in the group. The clear is stamped with
+// the current time. Afterwards, received items that are not newer than that are dropped,
+// so that late-arriving puts can't resurrect cleared items. Put rejects such items with
+// ErrStaleItem, so that the nodes don't diverge.
+func (m *Multicast) ClearAll() error {
+	now := m.now()
+
+	mp := &multicastPacket{
+		operation: operationClear,
+		key:       m.transmitter.id,
+		time:      now,
+	}
+	if err := m.send(mp); err != nil {
+		return fmt.Errorf("send clear: %w", err)
+	}
+	if err := m.Flush(); err != nil {
+		return fmt.Errorf("send clear: %w", err)
+	}
+
+	m.clear(now)
+	return nil
+}
+
+// ClearLocal performs a clear on the parent fader only.
+func (m *Multicast) ClearLocal() {
+	m.parent.Clear()
+}
+
+// ClearedAt returns the time of the latest clear of the group, or the zero time if there
+// hasn't been any.
+func (m *Multicast) ClearedAt() time.Time {
+	if clearedAt := m.clearedAt.Load(); clearedAt != 0 {
+		return time.Unix(0, clearedAt)
+	}
+	return time.Time{}
+}
+
+func (m *Multicast) handleClear(mp *multicastPacket) {
+	m.counters.clearsReceived.Add(1)
+	m.clear(mp.time)
+}
+
+// clear removes all items from the parent that are not newer than the given time. Items
+// that are newer, might have overtaken the clear on their way and are kept, if the parent
+// fader implements Ranger.
+func (m *Multicast) clear(t time.Time) {
+	m.clearMutex.Lock()
+	defer m.clearMutex.Unlock()
+
+	if t.UnixNano() <= m.clearedAt.Load() {
+		return
+	}
+	m.clearedAt.Store(t.UnixNano())
+
+	newerItems := []item{}
+	if ranger, ok := m.parent.(Ranger); ok {
+		ranger.Range(func(key []byte, itemTime time.Time, value []byte) bool {
+			if itemTime.After(t) {
+				newerItems = append(newerItems, item{key: key, time: itemTime, value: value})
+			}
+			return true
+		})
+	}
+
+	m.parent.Clear()
+
+	for _, item := range newerItems {
+		if err := m.parent.Put(item.key, item.time, item.value); err != nil {
+			m.logger.Printf("restore item after clear: %v", err)
+		}
+	}
+}
+
+// putUnlessStale puts the item into the parent fader, unless it has been cleared already.
+// It's serialized with the clears, so that an item can't be put between the collection
+// and the restore of the newer items and get lost.
+func (m *Multicast) putUnlessStale(key []byte, t time.Time, value []byte) (bool, error) {
+	m.clearMutex.RLock()
+	defer m.clearMutex.RUnlock()
+
+	if m.stale(t) {
+		return false, nil
+	}
+	return true, m.parent.Put(key, t, value)
+}
+
+// stale returns true if an item with the given time has been cleared already.
+func (m *Multicast) stale(t time.Time) bool {
+	clearedAt := m.clearedAt.Load()
+	return clearedAt != 0 && t.UnixNano() <= clearedAt
+}
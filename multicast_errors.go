@@ -0,0 +1,113 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrReplayedPacket is returned if a packet is received with a nonce, that isn't larger
+// than the last nonce of its sender. Besides replays, this happens to packets, that are
+// reordered on their way.
+var ErrReplayedPacket = errors.New("replayed packet")
+
+// ReceiveErrorKind classifies the errors, that occur while receiving packets.
+type ReceiveErrorKind int
+
+// The kinds of receive errors.
+const (
+	ReceiveErrorTransport ReceiveErrorKind = iota
+	ReceiveErrorDecrypt
+	ReceiveErrorReplay
+	ReceiveErrorDecode
+	ReceiveErrorParent
+	ReceiveErrorSignature
+)
+
+func (k ReceiveErrorKind) String() string {
+	switch k {
+	case ReceiveErrorTransport:
+		return "transport"
+	case ReceiveErrorDecrypt:
+		return "decrypt"
+	case ReceiveErrorReplay:
+		return "replay"
+	case ReceiveErrorDecode:
+		return "decode"
+	case ReceiveErrorParent:
+		return "parent"
+	case ReceiveErrorSignature:
+		return "signature"
+	default:
+		return fmt.Sprintf("ReceiveErrorKind(%d)", int(k))
+	}
+}
+
+// ReceiveError is passed to the error handler, if receiving a packet fails. The sender
+// is only known for errors after the decryption. The channel is only set for parent
+// errors of a channel.
+type ReceiveError struct {
+	Kind    ReceiveErrorKind
+	Sender  []byte
+	Channel string
+	Err     error
+}
+
+func (e *ReceiveError) Error() string {
+	return fmt.Sprintf("%s error: %v", e.Kind, e.Err)
+}
+
+func (e *ReceiveError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorHandler defines a handler for receive errors. The affected packet is dropped in any
+// case. If the handler returns false, the fader stops receiving packets.
+type ErrorHandler func(*ReceiveError) bool
+
+// WithErrorHandler sets the function, that is called for every receive error. Without
+// it, the errors are logged and the fader keeps on receiving.
+func WithErrorHandler(handler ErrorHandler) MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		o.errorHandler = handler
+		return nil
+	})
+}
+
+// handleError counts the error and passes it to the error handler. It returns false, if
+// the receive loop should stop.
+func (m *Multicast) handleError(err *ReceiveError) bool {
+	switch err.Kind {
+	case ReceiveErrorTransport:
+		m.counters.transportErrors.Add(1)
+	case ReceiveErrorDecrypt:
+		m.counters.decryptErrors.Add(1)
+	case ReceiveErrorReplay:
+		m.counters.replayedPackets.Add(1)
+	case ReceiveErrorDecode:
+		m.counters.decodeErrors.Add(1)
+	case ReceiveErrorParent:
+		m.counters.parentErrors.Add(1)
+	case ReceiveErrorSignature:
+		m.counters.signatureErrors.Add(1)
+	}
+
+	if m.errorHandler == nil {
+		m.logger.Printf("receive packet: %v", err)
+		return true
+	}
+	return m.errorHandler(err)
+}
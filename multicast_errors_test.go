@@ -0,0 +1,88 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package fader_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/posteo/fader"
+)
+
+func TestMulticastErrorHandler(t *testing.T) {
+	bus := fader.NewBus()
+	errs := make(chan *fader.ReceiveError, 10)
+	stop := atomic.Bool{}
+	receiver, parent := setUpBusFader(t, bus, "receiver", multicastFaderIDOne,
+		fader.WithErrorHandler(func(err *fader.ReceiveError) bool {
+			errs <- err
+			return !stop.Load()
+		}))
+
+	spy, err := bus.Transport("spy")
+	require.NoError(t, err)
+	defer spy.Close()
+
+	sender, _ := setUpBusFader(t, bus, "sender", multicastFaderIDTwo)
+
+	t.Run("Decrypt", func(t *testing.T) {
+		require.NoError(t, spy.Send(make([]byte, 64)))
+
+		err := <-errs
+		assert.Equal(t, fader.ReceiveErrorDecrypt, err.Kind)
+		assert.Nil(t, err.Sender)
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		require.NoError(t, sender.Put([]byte("key"), time.Now(), []byte("value")))
+		buffer := make([]byte, 1024)
+		n, _, err := spy.Receive(buffer)
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+
+		require.NoError(t, spy.Send(buffer[:n]))
+
+		receiveErr := <-errs
+		assert.Equal(t, fader.ReceiveErrorReplay, receiveErr.Kind)
+		assert.Equal(t, multicastFaderIDTwo, receiveErr.Sender)
+		assert.True(t, errors.Is(receiveErr, fader.ErrReplayedPacket))
+	})
+
+	t.Run("Parent", func(t *testing.T) {
+		require.NoError(t, parent.Close())
+
+		for range 2 {
+			require.NoError(t, sender.Put([]byte("key"), time.Now(), []byte("value")))
+			receiveErr := <-errs
+			assert.Equal(t, fader.ReceiveErrorParent, receiveErr.Kind)
+			assert.True(t, errors.Is(receiveErr, fader.ErrClosed))
+		}
+		assert.Equal(t, uint64(2), receiver.Stats().ParentErrors)
+	})
+
+	t.Run("Stop", func(t *testing.T) {
+		stop.Store(true)
+		require.NoError(t, sender.Put([]byte("key"), time.Now(), []byte("value")))
+		<-errs
+
+		require.NoError(t, sender.Put([]byte("key"), time.Now(), []byte("value")))
+		time.Sleep(10 * time.Millisecond)
+		assert.Empty(t, errs)
+	})
+}
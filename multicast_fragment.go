@@ -0,0 +1,253 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+// A packet that exceeds the write buffer size is split into fragments. Each fragment is
+// sent as a packet with the fragment operation, that carries the message id, the index
+// and the count of fragments as key and a chunk of the original packet as value. All
+// chunks but the last have the full chunk size.
+const (
+	fragmentHeaderSize       = 4 + 2 + 2
+	fragmentOverhead         = multicastPacketHeaderSize + 2 + fragmentHeaderSize + timeSize + 2
+	fragmentChunkSize        = maximalWriteBufferSize - fragmentOverhead
+	defaultMaximalItemSize   = 1 << 20
+	defaultReassemblyTimeout = 5 * time.Second
+	defaultReassemblyMemory  = 1 << 24
+
+	// The memory of an incomplete packet includes a slot for each of its chunks and the
+	// bookkeeping of the entry. A sender may only have a few incomplete packets at a time.
+	reassemblySlotSize         = int(unsafe.Sizeof([]byte(nil)))
+	reassemblyEntryOverhead    = 256
+	maximalPendingReassemblies = 16
+)
+
+var (
+	// ErrItemTooLarge is returned if an item exceeds the maximal item size.
+	ErrItemTooLarge = errors.New("item too large")
+
+	// ErrInvalidFragment is returned if a received fragment has an inconsistent header.
+	ErrInvalidFragment = errors.New("invalid fragment")
+)
+
+type fragmentHeader struct {
+	messageID uint32
+	index     uint16
+	count     uint16
+}
+
+func (h fragmentHeader) MarshalBinary() ([]byte, error) {
+	buffer := make([]byte, fragmentHeaderSize)
+	binary.BigEndian.PutUint32(buffer[0:4], h.messageID)
+	binary.BigEndian.PutUint16(buffer[4:6], h.index)
+	binary.BigEndian.PutUint16(buffer[6:8], h.count)
+	return buffer, nil
+}
+
+func (h *fragmentHeader) UnmarshalBinary(buffer []byte) error {
+	if len(buffer) != fragmentHeaderSize {
+		return fmt.Errorf("header of size %d: %w", len(buffer), ErrInvalidFragment)
+	}
+	h.messageID = binary.BigEndian.Uint32(buffer[0:4])
+	h.index = binary.BigEndian.Uint16(buffer[4:6])
+	h.count = binary.BigEndian.Uint16(buffer[6:8])
+	if h.count == 0 || h.index >= h.count {
+		return fmt.Errorf("fragment %d of %d: %w", h.index, h.count, ErrInvalidFragment)
+	}
+	return nil
+}
+
+// fragment splits the packet into fragment packets, that fit into the write buffer.
+func fragment(messageID uint32, packet []byte) ([][]byte, error) {
+	count := (len(packet) + fragmentChunkSize - 1) / fragmentChunkSize
+	if count > 0xffff {
+		return nil, fmt.Errorf("%d fragments: %w", count, ErrItemTooLarge)
+	}
+
+	fragments := make([][]byte, 0, count)
+	for index := 0; index < count; index++ {
+		start, end := index*fragmentChunkSize, (index+1)*fragmentChunkSize
+		if end > len(packet) {
+			end = len(packet)
+		}
+
+		header, _ := fragmentHeader{messageID, uint16(index), uint16(count)}.MarshalBinary()
+		mp := multicastPacket{
+			operation: operationFragment,
+			key:       header,
+			value:     packet[start:end],
+		}
+		fragment, err := mp.MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("marshal fragment: %w", err)
+		}
+		fragments = append(fragments, fragment)
+	}
+	return fragments, nil
+}
+
+type reassemblyKey struct {
+	sender    string
+	messageID uint32
+}
+
+type reassembly struct {
+	key     reassemblyKey
+	chunks  [][]byte
+	missing int
+	size    int
+	started time.Time
+}
+
+// memory returns the number of bytes, that the entry occupies.
+func (e *reassembly) memory() int {
+	return reassemblyEntryOverhead + len(e.chunks)*reassemblySlotSize + e.size
+}
+
+// reassembler collects fragments until a packet is complete. Incomplete packets are
+// dropped after a timeout or, if the memory limit is reached, in the order they were
+// started.
+type reassembler struct {
+	timeout     time.Duration
+	memoryLimit int
+	memory      int
+	pending     map[reassemblyKey]*list.Element
+	senders     map[string]int
+	order       *list.List
+	counters    *multicastCounters
+}
+
+func newReassembler(timeout time.Duration, memoryLimit int, counters *multicastCounters) *reassembler {
+	return &reassembler{
+		timeout:     timeout,
+		memoryLimit: memoryLimit,
+		pending:     make(map[reassemblyKey]*list.Element),
+		senders:     make(map[string]int),
+		order:       list.New(),
+		counters:    counters,
+	}
+}
+
+// add places the fragment and returns the reassembled packet, once all fragments of it
+// have been received. The memory of a new entry is accounted before it's allocated.
+func (r *reassembler) add(sender []byte, mp *multicastPacket, maximalItemSize int, now time.Time) ([]byte, error) {
+	r.expire(now)
+
+	header := fragmentHeader{}
+	if err := header.UnmarshalBinary(mp.key); err != nil {
+		return nil, err
+	}
+	if (int(header.count)-1)*fragmentChunkSize > maximalItemSize+multicastPacketHeaderSize+channelIDSize+2+timeSize+4 {
+		return nil, fmt.Errorf("%d fragments: %w", header.count, ErrItemTooLarge)
+	}
+
+	last := header.index == header.count-1
+	if len(mp.value) == 0 || len(mp.value) > fragmentChunkSize || !last && len(mp.value) != fragmentChunkSize {
+		return nil, fmt.Errorf("chunk %d of size %d: %w", header.index, len(mp.value), ErrInvalidFragment)
+	}
+
+	key := reassemblyKey{string(sender), header.messageID}
+	element, found := r.pending[key]
+	if !found {
+		size := reassemblyEntryOverhead + int(header.count)*reassemblySlotSize
+		if size+len(mp.value) > r.memoryLimit {
+			r.counters.reassembliesDropped.Add(1)
+			return nil, nil
+		}
+		if r.senders[key.sender] >= maximalPendingReassemblies {
+			r.counters.reassembliesDropped.Add(1)
+			r.remove(r.oldest(key.sender))
+		}
+		for r.memory+size > r.memoryLimit {
+			r.counters.reassembliesDropped.Add(1)
+			r.remove(r.order.Front())
+		}
+
+		element = r.order.PushBack(&reassembly{
+			key:     key,
+			chunks:  make([][]byte, header.count),
+			missing: int(header.count),
+			started: now,
+		})
+		r.pending[key] = element
+		r.senders[key.sender]++
+		r.memory += size
+	}
+
+	entry := element.Value.(*reassembly)
+	if len(entry.chunks) != int(header.count) {
+		return nil, fmt.Errorf("fragment count %d != %d: %w", header.count, len(entry.chunks), ErrInvalidFragment)
+	}
+	if entry.chunks[header.index] != nil {
+		return nil, nil
+	}
+
+	entry.chunks[header.index] = mp.value
+	entry.missing--
+	entry.size += len(mp.value)
+	r.memory += len(mp.value)
+
+	if entry.missing == 0 {
+		r.remove(element)
+
+		packet := make([]byte, 0, entry.size)
+		for _, chunk := range entry.chunks {
+			packet = append(packet, chunk...)
+		}
+		return packet, nil
+	}
+
+	for r.memory > r.memoryLimit {
+		r.counters.reassembliesDropped.Add(1)
+		r.remove(r.order.Front())
+	}
+	return nil, nil
+}
+
+func (r *reassembler) expire(now time.Time) {
+	for element := r.order.Front(); element != nil; element = r.order.Front() {
+		if now.Sub(element.Value.(*reassembly).started) <= r.timeout {
+			break
+		}
+		r.counters.reassembliesTimedOut.Add(1)
+		r.remove(element)
+	}
+}
+
+func (r *reassembler) oldest(sender string) *list.Element {
+	for element := r.order.Front(); element != nil; element = element.Next() {
+		if element.Value.(*reassembly).key.sender == sender {
+			return element
+		}
+	}
+	return nil
+}
+
+func (r *reassembler) remove(element *list.Element) {
+	entry := r.order.Remove(element).(*reassembly)
+	r.memory -= entry.memory()
+	delete(r.pending, entry.key)
+	if r.senders[entry.key.sender]--; r.senders[entry.key.sender] == 0 {
+		delete(r.senders, entry.key.sender)
+	}
+}
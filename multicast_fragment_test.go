@@ -0,0 +1,135 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fragmentPackets(tb testing.TB, messageID uint32, packet []byte) []*multicastPacket {
+	fragments, err := fragment(messageID, packet)
+	require.NoError(tb, err)
+
+	result := []*multicastPacket{}
+	for _, fragment := range fragments {
+		require.True(tb, len(fragment) <= maximalWriteBufferSize)
+		mp := &multicastPacket{}
+		require.NoError(tb, mp.UnmarshalBinary(fragment))
+		result = append(result, mp)
+	}
+	return result
+}
+
+func TestReassembler(t *testing.T) {
+	sender := []byte("sender")
+	packet := bytes.Repeat([]byte("0123456789"), 200)
+	now := time.Now()
+
+	t.Run("OutOfOrder", func(t *testing.T) {
+		r := newReassembler(defaultReassemblyTimeout, defaultReassemblyMemory, &multicastCounters{})
+		fragments := fragmentPackets(t, 1, packet)
+		require.Len(t, fragments, 5)
+
+		for _, index := range []int{4, 0, 2, 2, 1} {
+			result, err := r.add(sender, fragments[index], defaultMaximalItemSize, now)
+			require.NoError(t, err)
+			assert.Nil(t, result)
+		}
+		result, err := r.add(sender, fragments[3], defaultMaximalItemSize, now)
+		require.NoError(t, err)
+		assert.Equal(t, packet, result)
+		assert.Equal(t, 0, r.memory)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		counters := &multicastCounters{}
+		r := newReassembler(defaultReassemblyTimeout, defaultReassemblyMemory, counters)
+		fragments := fragmentPackets(t, 1, packet)
+
+		_, err := r.add(sender, fragments[0], defaultMaximalItemSize, now)
+		require.NoError(t, err)
+		_, err = r.add(sender, fragments[1], defaultMaximalItemSize, now.Add(2*defaultReassemblyTimeout))
+		require.NoError(t, err)
+
+		assert.Equal(t, uint64(1), counters.reassembliesTimedOut.Load())
+		assert.Len(t, r.pending, 1)
+	})
+
+	t.Run("MemoryLimit", func(t *testing.T) {
+		counters := &multicastCounters{}
+		entrySize := reassemblyEntryOverhead + 5*reassemblySlotSize + fragmentChunkSize
+		r := newReassembler(defaultReassemblyTimeout, entrySize, counters)
+
+		_, err := r.add(sender, fragmentPackets(t, 1, packet)[0], defaultMaximalItemSize, now)
+		require.NoError(t, err)
+		_, err = r.add(sender, fragmentPackets(t, 2, packet)[0], defaultMaximalItemSize, now)
+		require.NoError(t, err)
+
+		assert.Equal(t, uint64(1), counters.reassembliesDropped.Load())
+		assert.Len(t, r.pending, 1)
+		assert.Equal(t, entrySize, r.memory)
+	})
+
+	t.Run("SlotsExceedMemoryLimit", func(t *testing.T) {
+		counters := &multicastCounters{}
+		r := newReassembler(defaultReassemblyTimeout, reassemblyEntryOverhead+fragmentChunkSize, counters)
+
+		_, err := r.add(sender, fragmentPackets(t, 1, packet)[0], defaultMaximalItemSize, now)
+		require.NoError(t, err)
+
+		assert.Equal(t, uint64(1), counters.reassembliesDropped.Load())
+		assert.Empty(t, r.pending)
+		assert.Equal(t, 0, r.memory)
+	})
+
+	t.Run("PendingPerSender", func(t *testing.T) {
+		counters := &multicastCounters{}
+		r := newReassembler(defaultReassemblyTimeout, defaultReassemblyMemory, counters)
+
+		for messageID := uint32(0); messageID <= maximalPendingReassemblies; messageID++ {
+			_, err := r.add(sender, fragmentPackets(t, messageID, packet)[0], defaultMaximalItemSize, now)
+			require.NoError(t, err)
+		}
+		_, err := r.add([]byte("other"), fragmentPackets(t, 0, packet)[0], defaultMaximalItemSize, now)
+		require.NoError(t, err)
+
+		assert.Equal(t, uint64(1), counters.reassembliesDropped.Load())
+		assert.Len(t, r.pending, maximalPendingReassemblies+1)
+		assert.NotContains(t, r.pending, reassemblyKey{string(sender), 0})
+	})
+
+	t.Run("ShortChunk", func(t *testing.T) {
+		r := newReassembler(defaultReassemblyTimeout, defaultReassemblyMemory, &multicastCounters{})
+		fragment := fragmentPackets(t, 1, packet)[0]
+		fragment.value = fragment.value[:1]
+
+		_, err := r.add(sender, fragment, defaultMaximalItemSize, now)
+		assert.True(t, errors.Is(err, ErrInvalidFragment))
+		assert.Empty(t, r.pending)
+	})
+
+	t.Run("ItemTooLarge", func(t *testing.T) {
+		r := newReassembler(defaultReassemblyTimeout, defaultReassemblyMemory, &multicastCounters{})
+
+		_, err := r.add(sender, fragmentPackets(t, 1, packet)[0], 1000, now)
+		assert.True(t, errors.Is(err, ErrItemTooLarge))
+	})
+}
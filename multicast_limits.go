@@ -0,0 +1,239 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// maximalLimitedSenders is the number of senders, whose buckets are kept before idle
+// buckets are dropped.
+const maximalLimitedSenders = 4096
+
+// RateLimits configures the limits of the received frames and items. A rate of zero
+// disables the respective limit. If a burst is zero, it defaults to the rate rounded up.
+type RateLimits struct {
+	// SenderRate limits the frames per second of each sender.
+	SenderRate  float64
+	SenderBurst int
+	// GlobalRate limits the frames per second of all senders together. It's checked
+	// before a frame is decrypted, so it also bounds the work spent on forged frames.
+	// All frames count, including the ones that are dropped by the sender limit and the
+	// node's own frames, that the transport loops back. The sender of a frame is only
+	// known after decryption, so a node with loopback should allow for its own send rate.
+	GlobalRate  float64
+	GlobalBurst int
+	// PutRate limits the received items per second, that are put into the parent fader.
+	PutRate  float64
+	PutBurst int
+	// If a sender exceeds its limit by BanThreshold frames within BanDuration, all its
+	// frames are dropped for BanDuration. A threshold of zero disables bans.
+	BanThreshold int
+	BanDuration  time.Duration
+}
+
+// SetRateLimits sets the limits of the received frames and items. Dropped frames and
+// items are counted in the stats. Except for the global limit, the limits are checked
+// after the decryption, so the sender is known.
+func (m *Multicast) SetRateLimits(limits RateLimits) error {
+	l, err := newRateLimiter(limits)
+	if err != nil {
+		return err
+	}
+	m.rateLimiter.Store(l)
+	return nil
+}
+
+// WithRateLimits sets the limits of the received frames and items. See SetRateLimits.
+func WithRateLimits(limits RateLimits) MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		l, err := newRateLimiter(limits)
+		if err != nil {
+			return err
+		}
+		o.settings = append(o.settings, func(m *Multicast) { m.rateLimiter.Store(l) })
+		return nil
+	})
+}
+
+type rateLimiter struct {
+	limits  RateLimits
+	mutex   sync.Mutex
+	senders map[string]*senderLimit
+	global  tokenBucket
+	puts    tokenBucket
+}
+
+type senderLimit struct {
+	bucket      tokenBucket
+	drops       int
+	windowStart time.Time
+	bannedUntil time.Time
+}
+
+func newRateLimiter(limits RateLimits) (*rateLimiter, error) {
+	switch {
+	case limits.SenderRate < 0 || limits.SenderBurst < 0:
+		return nil, fmt.Errorf("sender rate %v/%d: %w", limits.SenderRate, limits.SenderBurst, ErrInvalidOption)
+	case limits.GlobalRate < 0 || limits.GlobalBurst < 0:
+		return nil, fmt.Errorf("global rate %v/%d: %w", limits.GlobalRate, limits.GlobalBurst, ErrInvalidOption)
+	case limits.PutRate < 0 || limits.PutBurst < 0:
+		return nil, fmt.Errorf("put rate %v/%d: %w", limits.PutRate, limits.PutBurst, ErrInvalidOption)
+	case limits.BanThreshold < 0 || (limits.BanThreshold > 0 && limits.BanDuration <= 0):
+		return nil, fmt.Errorf("ban %d/%s: %w", limits.BanThreshold, limits.BanDuration, ErrInvalidOption)
+	}
+
+	return &rateLimiter{
+		limits:  limits,
+		senders: make(map[string]*senderLimit),
+		global:  newTokenBucket(limits.GlobalRate, limits.GlobalBurst),
+		puts:    newTokenBucket(limits.PutRate, limits.PutBurst),
+	}, nil
+}
+
+// withinGlobalLimit returns true, if another frame may be decrypted. Frames, that are
+// not, are only counted as limited.
+func (m *Multicast) withinGlobalLimit() bool {
+	l := m.rateLimiter.Load()
+	if l == nil || l.limits.GlobalRate == 0 {
+		return true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.global.take(m.now()) {
+		m.counters.framesLimited.Add(1)
+		return false
+	}
+	return true
+}
+
+// withinLimits returns true, if the frame is within the limits of its sender.
+func (m *Multicast) withinLimits(frame *receivedFrame) bool {
+	l := m.rateLimiter.Load()
+	if l == nil {
+		return true
+	}
+	now := m.now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.limits.SenderRate > 0 {
+		s := l.sender(frame.sender, now)
+		if now.Before(s.bannedUntil) {
+			m.counters.bannedFrames.Add(1)
+			return false
+		}
+		if !s.bucket.take(now) {
+			m.counters.senderFramesLimited.Add(1)
+			if l.limits.BanThreshold > 0 {
+				if now.Sub(s.windowStart) > l.limits.BanDuration {
+					s.drops, s.windowStart = 0, now
+				}
+				s.drops++
+				if s.drops >= l.limits.BanThreshold {
+					s.drops, s.bannedUntil = 0, now.Add(l.limits.BanDuration)
+					m.counters.senderBans.Add(1)
+				}
+			}
+			return false
+		}
+	}
+
+	return true
+}
+
+// withinPutLimit returns true, if a received item may be put into the parent fader.
+func (m *Multicast) withinPutLimit() bool {
+	l := m.rateLimiter.Load()
+	if l == nil || l.limits.PutRate == 0 {
+		return true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.puts.take(m.now()) {
+		m.counters.putsLimited.Add(1)
+		return false
+	}
+	return true
+}
+
+// sender returns the limit of the given sender. If there are too many senders, the ones,
+// that are idle and not banned, are dropped first.
+func (l *rateLimiter) sender(id []byte, now time.Time) *senderLimit {
+	if s, found := l.senders[string(id)]; found {
+		return s
+	}
+
+	if len(l.senders) >= maximalLimitedSenders {
+		for key, s := range l.senders {
+			if s.bucket.full(now) && !now.Before(s.bannedUntil) {
+				delete(l.senders, key)
+			}
+		}
+	}
+
+	s := &senderLimit{bucket: newTokenBucket(l.limits.SenderRate, l.limits.SenderBurst)}
+	l.senders[string(id)] = s
+	return s
+}
+
+// tokenBucket allows bursts of up to burst events and refills at the given rate per
+// second.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) tokenBucket {
+	if burst == 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+func (b *tokenBucket) take(now time.Time) bool {
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
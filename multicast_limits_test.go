@@ -0,0 +1,125 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package fader_test
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/posteo/fader"
+)
+
+func TestMulticastRateLimits(t *testing.T) {
+	// The clock of the receiver stands still, so the buckets are not refilled.
+	setUp := func(t *testing.T, limits fader.RateLimits) (*fader.Multicast, *fader.Memory, *atomic.Int64, *fader.Bus) {
+		bus := fader.NewBus()
+		now := &atomic.Int64{}
+		now.Store(time.Now().UnixNano())
+		receiver, parent := setUpBusFader(t, bus, "receiver", multicastFaderIDOne,
+			fader.WithClock(func() time.Time { return time.Unix(0, now.Load()) }),
+			fader.WithRateLimits(limits))
+		return receiver, parent, now, bus
+	}
+	sender := func(t *testing.T, bus *fader.Bus, id []byte) *fader.Multicast {
+		m, _ := setUpBusFader(t, bus, fmt.Sprintf("sender-%x", id), id)
+		return m
+	}
+	put := func(t *testing.T, m *fader.Multicast, prefix string, count int) {
+		for index := range count {
+			require.NoError(t, m.Put(fmt.Appendf(nil, "%s-%d", prefix, index), time.Now(), []byte("value")))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Run("Sender", func(t *testing.T) {
+		receiver, parent, now, bus := setUp(t, fader.RateLimits{
+			SenderRate:   1,
+			SenderBurst:  3,
+			BanThreshold: 3,
+			BanDuration:  time.Minute,
+		})
+		two := sender(t, bus, multicastFaderIDTwo)
+
+		put(t, two, "two", 10)
+
+		stats := receiver.Stats()
+		assert.Equal(t, uint64(3), stats.SenderFramesLimited)
+		assert.Equal(t, uint64(1), stats.SenderBans)
+		assert.Equal(t, stats.DatagramsReceived-6, stats.BannedFrames)
+		assert.True(t, parent.Size() <= 3)
+
+		size := parent.Size()
+		put(t, sender(t, bus, multicastFaderIDThree), "three", 1)
+		assert.Equal(t, size+1, parent.Size())
+
+		now.Add(int64(2 * time.Minute))
+		put(t, two, "later", 1)
+		assert.Equal(t, size+2, parent.Size())
+	})
+
+	t.Run("Global", func(t *testing.T) {
+		receiver, _, _, bus := setUp(t, fader.RateLimits{GlobalRate: 1, GlobalBurst: 2})
+
+		put(t, sender(t, bus, multicastFaderIDTwo), "two", 3)
+		put(t, sender(t, bus, multicastFaderIDThree), "three", 3)
+
+		stats := receiver.Stats()
+		assert.Equal(t, uint64(2), stats.DatagramsReceived)
+		assert.True(t, stats.FramesLimited >= 4)
+
+		// The limit is checked before the decryption.
+		spy, err := bus.Transport("spy")
+		require.NoError(t, err)
+		defer spy.Close()
+		require.NoError(t, spy.Send(make([]byte, 64)))
+		time.Sleep(10 * time.Millisecond)
+
+		assert.Equal(t, uint64(0), receiver.Stats().DecryptErrors)
+		assert.Equal(t, stats.FramesLimited+1, receiver.Stats().FramesLimited)
+	})
+
+	t.Run("Puts", func(t *testing.T) {
+		bus := fader.NewBus()
+		handled, now := atomic.Int64{}, time.Now()
+		receiver, parent := setUpBusFader(t, bus, "receiver", multicastFaderIDOne,
+			fader.WithClock(func() time.Time { return now }),
+			fader.WithRateLimits(fader.RateLimits{PutRate: 1, PutBurst: 2}),
+			fader.WithItemHandler(func(*fader.ReceivedItem) bool {
+				handled.Add(1)
+				return true
+			}))
+
+		put(t, sender(t, bus, multicastFaderIDTwo), "two", 5)
+
+		assert.Equal(t, 2, parent.Size())
+		assert.Equal(t, uint64(3), receiver.Stats().PutsLimited)
+		// The handler isn't called for limited items.
+		assert.Equal(t, int64(2), handled.Load())
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		receiver, _, _, _ := setUp(t, fader.RateLimits{})
+
+		err := receiver.SetRateLimits(fader.RateLimits{SenderRate: -1})
+		assert.True(t, errors.Is(err, fader.ErrInvalidOption))
+		err = receiver.SetRateLimits(fader.RateLimits{BanThreshold: 1})
+		assert.True(t, errors.Is(err, fader.ErrInvalidOption))
+	})
+}
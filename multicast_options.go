@@ -0,0 +1,333 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/posteo/fader/crypt"
+)
+
+// MulticastOption configures a multicast fader.
+type MulticastOption interface {
+	applyMulticast(*multicastOptions) error
+}
+
+type multicastOption func(*multicastOptions) error
+
+func (o multicastOption) applyMulticast(options *multicastOptions) error {
+	return o(options)
+}
+
+type multicastOptions struct {
+	address             string
+	transportConfig     MulticastTransportConfig
+	socketOptions       bool
+	transport           Transport
+	key                 []byte
+	keyring             *crypt.Keyring
+	id                  []byte
+	signer              *signer
+	itemReceivedHandler ReceivedHandler
+	itemHandler         ItemHandler
+	errorHandler        ErrorHandler
+	ownsParent          bool
+	syncOnJoin          bool
+	reassemblyTimeout   time.Duration
+	reassemblyMemory    int
+	logger              *log.Logger
+	now                 func() time.Time
+	settings            []func(*Multicast)
+}
+
+// NewMulticastWithOptions creates a Fader instance like NewMulticast, that is configured
+// by the given options. Either an address or a transport, and either a key or a keyring
+// must be given. An error is returned if an option is invalid or the options don't fit
+// together.
+//
+//	multicastFader, err := fader.NewMulticastWithOptions(memoryFader,
+//		fader.WithAddress("224.0.0.1:2000"),
+//		fader.WithKey(key),
+//		fader.WithInterface("eth0"),
+//	)
+func NewMulticastWithOptions(parent Fader, options ...MulticastOption) (*Multicast, error) {
+	o := &multicastOptions{}
+	for _, option := range options {
+		if err := option.applyMulticast(o); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case o.key != nil && o.keyring != nil:
+		return nil, fmt.Errorf("both key and keyring: %w", ErrInvalidOption)
+	case o.key != nil:
+		keyring, err := crypt.NewKeyring(0, o.key)
+		if err != nil {
+			return nil, fmt.Errorf("new keyring: %w", err)
+		}
+		o.keyring = keyring
+	case o.keyring == nil:
+		return nil, fmt.Errorf("missing key or keyring: %w", ErrInvalidOption)
+	}
+
+	if o.itemReceivedHandler != nil && o.itemHandler != nil {
+		return nil, fmt.Errorf("both received handler and item handler: %w", ErrInvalidOption)
+	}
+
+	switch {
+	case o.address != "" && o.transport != nil:
+		return nil, fmt.Errorf("both address and transport: %w", ErrInvalidOption)
+	case o.transport != nil && o.socketOptions:
+		return nil, fmt.Errorf("socket options with transport: %w", ErrInvalidOption)
+	case o.address != "":
+		transport, err := NewMulticastTransportWithConfig(o.address, o.transportConfig)
+		if err != nil {
+			return nil, err
+		}
+		o.transport = transport
+	case o.transport == nil:
+		return nil, fmt.Errorf("missing address or transport: %w", ErrInvalidOption)
+	}
+
+	m := newMulticast(parent, o)
+	for _, setting := range o.settings {
+		setting(m)
+	}
+	m.start()
+
+	return m, nil
+}
+
+// WithAddress sets the address of the udp multicast group.
+func WithAddress(address string) MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		o.address = address
+		return nil
+	})
+}
+
+// WithTransport sets a transport, that is used instead of udp multicast. The transport
+// is closed together with the fader.
+func WithTransport(transport Transport) MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		if transport == nil {
+			return fmt.Errorf("nil transport: %w", ErrInvalidOption)
+		}
+		o.transport = transport
+		return nil
+	})
+}
+
+// WithInterface sets the name or an address of the network interface of the multicast
+// group. See MulticastTransportConfig.
+func WithInterface(iface string) MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		o.transportConfig.Interface = iface
+		o.socketOptions = true
+		return nil
+	})
+}
+
+// WithTTL sets the time-to-live or hop limit of the multicast packets.
+func WithTTL(ttl int) MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		if ttl < 1 || ttl > 255 {
+			return fmt.Errorf("ttl %d: %w", ttl, ErrInvalidOption)
+		}
+		o.transportConfig.TTL = ttl
+		o.socketOptions = true
+		return nil
+	})
+}
+
+// WithoutLoopback prevents that the packets are delivered to other nodes on the same host.
+func WithoutLoopback() MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		o.transportConfig.DisableLoopback = true
+		o.socketOptions = true
+		return nil
+	})
+}
+
+// WithReceiveBufferSize sets the size of the socket's receive buffer.
+func WithReceiveBufferSize(size int) MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		if size < 1 {
+			return fmt.Errorf("receive buffer size %d: %w", size, ErrInvalidOption)
+		}
+		o.transportConfig.ReceiveBufferSize = size
+		o.socketOptions = true
+		return nil
+	})
+}
+
+// WithKey sets the key, that is placed with id 0 in the keyring. The length of the key
+// must be 16, 24 or 32.
+func WithKey(key []byte) MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		if length := len(key); length != 16 && length != 24 && length != 32 {
+			return fmt.Errorf("key length %d: %w", length, ErrInvalidKeyLength)
+		}
+		o.key = key
+		return nil
+	})
+}
+
+// WithKeyring sets the keyring, that is used to encrypt and decrypt packets.
+func WithKeyring(keyring *crypt.Keyring) MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		if keyring == nil {
+			return fmt.Errorf("nil keyring: %w", ErrInvalidOption)
+		}
+		o.keyring = keyring
+		return nil
+	})
+}
+
+// WithID sets the 10-byte long id of the node. Without it, a random id is generated.
+func WithID(id []byte) MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		if len(id) != idSize {
+			return fmt.Errorf("id of length %d: %w", len(id), ErrInvalidOption)
+		}
+		o.id = id
+		return nil
+	})
+}
+
+// WithReceivedHandler sets the function, that is called every time an item is received.
+// See NewMulticast.
+func WithReceivedHandler(handler ReceivedHandler) MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		o.itemReceivedHandler = handler
+		return nil
+	})
+}
+
+// WithItemHandler sets the function, that is called every time an item is received. In
+// contrast to WithReceivedHandler, the handler gets the sender and the frame of the item
+// and may modify the item before it's stored in the parent fader.
+func WithItemHandler(handler ItemHandler) MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		o.itemHandler = handler
+		return nil
+	})
+}
+
+// WithOwnedParent hands the ownership of the parent fader to the multicast fader, which
+// then shuts down the parent after itself. By default, the parent has to be closed by
+// the caller.
+func WithOwnedParent() MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		o.ownsParent = true
+		return nil
+	})
+}
+
+// WithSyncOnJoin makes the fader request the live items of its peers right after it has
+// been started, like a call of RequestSync does. Without it, a joining node only sees the
+// items that are put afterwards.
+func WithSyncOnJoin() MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		o.syncOnJoin = true
+		return nil
+	})
+}
+
+// WithMaximalItemSize sets the maximal size of key and value of an item. See
+// SetMaximalItemSize.
+func WithMaximalItemSize(size int) MulticastOption {
+	return multicastSetting(size < 1, "maximal item size", size, func(m *Multicast) { m.SetMaximalItemSize(size) })
+}
+
+// WithReassemblyTimeout sets the period after which an incomplete fragmented item is
+// dropped. The default is five seconds.
+func WithReassemblyTimeout(timeout time.Duration) MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		if timeout <= 0 {
+			return fmt.Errorf("reassembly timeout %v: %w", timeout, ErrInvalidOption)
+		}
+		o.reassemblyTimeout = timeout
+		return nil
+	})
+}
+
+// WithReassemblyMemory sets the number of bytes, that the fragments of incomplete items and
+// their bookkeeping may occupy. Once it's exceeded, the oldest incomplete items are dropped.
+// The default is 16 MiB. It should be larger than the maximal item size.
+func WithReassemblyMemory(size int) MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		if size < 1 {
+			return fmt.Errorf("reassembly memory %d: %w", size, ErrInvalidOption)
+		}
+		o.reassemblyMemory = size
+		return nil
+	})
+}
+
+// WithFlushInterval sets the period for which packets are held back. See SetFlushInterval.
+func WithFlushInterval(interval time.Duration) MulticastOption {
+	return multicastSetting(interval < 0, "flush interval", interval, func(m *Multicast) { m.SetFlushInterval(interval) })
+}
+
+// WithSyncRate sets the number of items per second, that are sent in response to a sync
+// request. See SetSyncRate.
+func WithSyncRate(rate int) MulticastOption {
+	return multicastSetting(rate < 1, "sync rate", rate, func(m *Multicast) { m.SetSyncRate(rate) })
+}
+
+// WithAntiEntropyInterval sets the interval in which the digest of the items is published.
+// See SetAntiEntropyInterval.
+func WithAntiEntropyInterval(interval time.Duration) MulticastOption {
+	return multicastSetting(interval < 0, "anti-entropy interval", interval, func(m *Multicast) { m.SetAntiEntropyInterval(interval) })
+}
+
+// WithAntiEntropyRate sets the number of items per second, that are sent in response to a
+// range request. See SetAntiEntropyRate.
+func WithAntiEntropyRate(rate int) MulticastOption {
+	return multicastSetting(rate < 1, "anti-entropy rate", rate, func(m *Multicast) { m.SetAntiEntropyRate(rate) })
+}
+
+// WithLabel sets the label, that is sent with the heartbeats. See SetLabel.
+func WithLabel(label string) MulticastOption {
+	return multicastSetting(false, "label", label, func(m *Multicast) { m.SetLabel(label) })
+}
+
+// WithHeartbeatInterval sets the interval in which heartbeats are sent. See
+// SetHeartbeatInterval.
+func WithHeartbeatInterval(interval time.Duration) MulticastOption {
+	return multicastSetting(interval <= 0, "heartbeat interval", interval, func(m *Multicast) { m.SetHeartbeatInterval(interval) })
+}
+
+// WithPeerHandler sets the function, that is called on changes of the peer table. See
+// SetPeerHandler.
+func WithPeerHandler(handler PeerHandler) MulticastOption {
+	return multicastSetting(false, "peer handler", nil, func(m *Multicast) { m.SetPeerHandler(handler) })
+}
+
+// multicastSetting returns an option, that applies the setting to the fader before it's
+// started, unless the value is invalid.
+func multicastSetting(invalid bool, name string, value any, setting func(*Multicast)) MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		if invalid {
+			return fmt.Errorf("%s %v: %w", name, value, ErrInvalidOption)
+		}
+		o.settings = append(o.settings, setting)
+		return nil
+	})
+}
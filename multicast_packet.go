@@ -16,53 +16,208 @@ package fader
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
 	"time"
 )
 
+// A packet of version 0 is a bare key/time/value tuple that starts with the key length.
+// Packets of later versions start with a marker byte, that can't be the first byte of
+// a version 0 packet, since the key of such a packet would never fit into a datagram.
+// The marker is followed by the version, the operation and the flags. Packets of a
+// channel have version 2 and carry the channel id after the flags, so that nodes without
+// channels drop them as packets of an unknown version.
+const (
+	multicastPacketMarker         = 0xff
+	multicastPacketVersion        = 1
+	multicastPacketChannelVersion = 2
+	multicastPacketHeaderSize     = 4
+	channelIDSize                 = 4
+)
+
+type multicastOperation uint8
+
+// Items expire instead of being deleted. The value 1 was reserved for deletes in the
+// first header version and stays unused, so that the values of the other operations
+// don't change on the wire.
+const (
+	operationPut multicastOperation = iota
+	_
+	operationClear
+	operationHeartbeat
+	operationSyncRequest
+	operationFragment
+	operationDigest
+	operationRangeRequest
+)
+
+// Values that don't fit into the 16 bit length of a packet, are flagged and carry a 32 bit
+// length instead. Such packets never fit into a datagram and are always fragmented.
+const (
+	flagLargeValue uint8 = 1 << 2
+
+	maximalKeySize   = math.MaxUint16
+	maximalValueSize = math.MaxUint32
+	timeSize         = 15
+)
+
+var (
+	// ErrUnknownVersion is returned if a packet of an unknown protocol version is received.
+	ErrUnknownVersion = errors.New("unknown packet version")
+
+	// ErrTruncatedPacket is returned if a received packet is shorter than its length
+	// fields claim.
+	ErrTruncatedPacket = errors.New("truncated packet")
+
+	// ErrMalformedPacket is returned if a received packet contains an invalid field.
+	ErrMalformedPacket = errors.New("malformed packet")
+
+	// ErrKeyTooLarge is returned if a key exceeds the size that fits into a packet.
+	ErrKeyTooLarge = errors.New("key too large")
+
+	// ErrValueTooLarge is returned if a value exceeds the size that fits into a packet.
+	ErrValueTooLarge = errors.New("value too large")
+)
+
 type multicastPacket struct {
-	key   []byte
-	time  time.Time
-	value []byte
+	version   uint8
+	operation multicastOperation
+	flags     uint8
+	channel   uint32
+	key       []byte
+	time      time.Time
+	value     []byte
 }
 
 func (mp *multicastPacket) MarshalBinary() ([]byte, error) {
-	buffer := make([]byte, 2+len(mp.key)+15+2+len(mp.value))
+	if len(mp.key) > maximalKeySize {
+		return nil, fmt.Errorf("key size %d: %w", len(mp.key), ErrKeyTooLarge)
+	}
+	if int64(len(mp.value)) > maximalValueSize {
+		return nil, fmt.Errorf("value size %d: %w", len(mp.value), ErrValueTooLarge)
+	}
+
+	time, err := mp.time.MarshalBinary()
+	if err == nil && len(time) != timeSize {
+		// zone offsets with seconds need an extended encoding, so fall back to UTC
+		time, err = mp.time.UTC().MarshalBinary()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("marshal time: %w", err)
+	}
+
+	headerSize, version := multicastPacketHeaderSize, byte(multicastPacketVersion)
+	if mp.channel != 0 {
+		headerSize, version = multicastPacketHeaderSize+channelIDSize, multicastPacketChannelVersion
+	}
+	flags, valueLengthSize := mp.flags&^flagLargeValue, 2
+	if len(mp.value) > math.MaxUint16 {
+		flags, valueLengthSize = flags|flagLargeValue, 4
+	}
+	buffer := make([]byte, headerSize+2+len(mp.key)+timeSize+valueLengthSize+len(mp.value))
 
 	index := 0
+	buffer[index] = multicastPacketMarker
+	buffer[index+1] = version
+	buffer[index+2] = byte(mp.operation)
+	buffer[index+3] = flags
+	index += multicastPacketHeaderSize
+
+	if mp.channel != 0 {
+		binary.BigEndian.PutUint32(buffer[index:index+channelIDSize], mp.channel)
+		index += channelIDSize
+	}
+
 	binary.BigEndian.PutUint16(buffer[index:index+2], uint16(len(mp.key)))
 	index += 2
 
 	index += copy(buffer[index:index+len(mp.key)], mp.key)
 
-	time, _ := mp.time.MarshalBinary()
-	index += copy(buffer[index:index+15], time)
+	index += copy(buffer[index:index+timeSize], time)
 
-	binary.BigEndian.PutUint16(buffer[index:index+2], uint16(len(mp.value)))
-	index += 2
+	if valueLengthSize == 4 {
+		binary.BigEndian.PutUint32(buffer[index:index+4], uint32(len(mp.value)))
+	} else {
+		binary.BigEndian.PutUint16(buffer[index:index+2], uint16(len(mp.value)))
+	}
+	index += valueLengthSize
 	copy(buffer[index:], mp.value)
 
 	return buffer, nil
 }
 
 func (mp *multicastPacket) UnmarshalBinary(buffer []byte) error {
+	_, err := mp.decode(buffer)
+	return err
+}
+
+// decode reads a packet from the beginning of the buffer and returns the number of bytes
+// it occupies. Since a datagram can carry several packets, the buffer may contain more
+// bytes than that.
+func (mp *multicastPacket) decode(buffer []byte) (int, error) {
 	index := 0
 
+	if len(buffer) < 1 {
+		return 0, fmt.Errorf("empty buffer: %w", ErrTruncatedPacket)
+	}
+
+	mp.version, mp.operation, mp.flags, mp.channel = 0, operationPut, 0, 0
+	largeValue := false
+	if buffer[index] == multicastPacketMarker {
+		if len(buffer) < multicastPacketHeaderSize {
+			return 0, fmt.Errorf("header: %w", ErrTruncatedPacket)
+		}
+		mp.version = buffer[index+1]
+		if mp.version != multicastPacketVersion && mp.version != multicastPacketChannelVersion {
+			return 0, fmt.Errorf("version %d: %w", mp.version, ErrUnknownVersion)
+		}
+		mp.operation = multicastOperation(buffer[index+2])
+		mp.flags = buffer[index+3] &^ flagLargeValue
+		largeValue = buffer[index+3]&flagLargeValue != 0
+		index += multicastPacketHeaderSize
+
+		if mp.version == multicastPacketChannelVersion {
+			if len(buffer) < index+channelIDSize {
+				return 0, fmt.Errorf("channel id: %w", ErrTruncatedPacket)
+			}
+			mp.channel = binary.BigEndian.Uint32(buffer[index : index+channelIDSize])
+			index += channelIDSize
+		}
+	}
+
+	if len(buffer) < index+2 {
+		return 0, fmt.Errorf("key size: %w", ErrTruncatedPacket)
+	}
 	keySize := int(binary.BigEndian.Uint16(buffer[index : index+2]))
 	index += 2
 
+	valueLengthSize := 2
+	if largeValue {
+		valueLengthSize = 4
+	}
+	if len(buffer) < index+keySize+timeSize+valueLengthSize {
+		return 0, fmt.Errorf("key of size %d: %w", keySize, ErrTruncatedPacket)
+	}
 	mp.key = make([]byte, keySize)
 	index += copy(mp.key, buffer[index:index+keySize])
 
-	if err := mp.time.UnmarshalBinary(buffer[index : index+15]); err != nil {
-		return err
+	if err := mp.time.UnmarshalBinary(buffer[index : index+timeSize]); err != nil {
+		return 0, fmt.Errorf("time: %v: %w", err, ErrMalformedPacket)
 	}
-	index += 15
+	index += timeSize
 
-	valueSize := int(binary.BigEndian.Uint16(buffer[index : index+2]))
-	index += 2
+	valueSize := uint64(binary.BigEndian.Uint16(buffer[index : index+2]))
+	if valueLengthSize == 4 {
+		valueSize = uint64(binary.BigEndian.Uint32(buffer[index : index+4]))
+	}
+	index += valueLengthSize
 
+	if uint64(len(buffer)-index) < valueSize {
+		return 0, fmt.Errorf("value of size %d: %w", valueSize, ErrTruncatedPacket)
+	}
 	mp.value = make([]byte, valueSize)
-	copy(mp.value, buffer[index:index+valueSize])
+	index += copy(mp.value, buffer[index:])
 
-	return nil
+	return index, nil
 }
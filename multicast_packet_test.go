@@ -0,0 +1,165 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func marshalVersionZeroPacket(key []byte, t time.Time, value []byte) []byte {
+	buffer := make([]byte, 2, 2+len(key)+15+2+len(value))
+	binary.BigEndian.PutUint16(buffer, uint16(len(key)))
+	buffer = append(buffer, key...)
+	timeBytes, _ := t.MarshalBinary()
+	buffer = append(buffer, timeBytes...)
+	buffer = binary.BigEndian.AppendUint16(buffer, uint16(len(value)))
+	return append(buffer, value...)
+}
+
+func TestMulticastPacket(t *testing.T) {
+	now := time.Unix(1400000000, 0).UTC()
+
+	t.Run("MarshalAndUnmarshal", func(t *testing.T) {
+		mp := &multicastPacket{
+			operation: operationClear,
+			flags:     3,
+			key:       []byte("key"),
+			time:      now,
+			value:     []byte("value"),
+		}
+		buffer, err := mp.MarshalBinary()
+		require.NoError(t, err)
+		assert.Equal(t, []byte{multicastPacketMarker, multicastPacketVersion, byte(operationClear), 3}, buffer[:4])
+
+		result := &multicastPacket{}
+		require.NoError(t, result.UnmarshalBinary(buffer))
+		assert.Equal(t, uint8(multicastPacketVersion), result.version)
+		assert.Equal(t, operationClear, result.operation)
+		assert.Equal(t, uint8(3), result.flags)
+		assert.Equal(t, "key", string(result.key))
+		assert.True(t, now.Equal(result.time))
+		assert.Equal(t, "value", string(result.value))
+	})
+
+	t.Run("Channel", func(t *testing.T) {
+		mp := &multicastPacket{channel: 0xfade, key: []byte("key"), time: now, value: []byte("value")}
+		buffer, err := mp.MarshalBinary()
+		require.NoError(t, err)
+		assert.Equal(t, []byte{multicastPacketMarker, multicastPacketChannelVersion, byte(operationPut), 0, 0, 0, 0xfa, 0xde}, buffer[:8])
+
+		result := &multicastPacket{}
+		require.NoError(t, result.UnmarshalBinary(buffer))
+		assert.Equal(t, uint32(0xfade), result.channel)
+		assert.Equal(t, "key", string(result.key))
+		assert.Equal(t, "value", string(result.value))
+
+		err = (&multicastPacket{}).UnmarshalBinary(buffer[:6])
+		assert.True(t, errors.Is(err, ErrTruncatedPacket))
+	})
+
+	t.Run("UnmarshalVersionZero", func(t *testing.T) {
+		result := &multicastPacket{}
+		require.NoError(t, result.UnmarshalBinary(marshalVersionZeroPacket([]byte("key"), now, []byte("value"))))
+		assert.Equal(t, uint8(0), result.version)
+		assert.Equal(t, operationPut, result.operation)
+		assert.Equal(t, "key", string(result.key))
+		assert.True(t, now.Equal(result.time))
+		assert.Equal(t, "value", string(result.value))
+	})
+
+	t.Run("UnknownVersion", func(t *testing.T) {
+		mp := &multicastPacket{key: []byte("key"), time: now}
+		buffer, err := mp.MarshalBinary()
+		require.NoError(t, err)
+		buffer[1] = multicastPacketChannelVersion + 1
+
+		err = (&multicastPacket{}).UnmarshalBinary(buffer)
+		assert.True(t, errors.Is(err, ErrUnknownVersion))
+	})
+}
+
+func TestMulticastPacketBounds(t *testing.T) {
+	t.Run("Truncated", func(t *testing.T) {
+		mp := &multicastPacket{key: []byte("key"), time: time.Now(), value: []byte("value")}
+		buffer, err := mp.MarshalBinary()
+		require.NoError(t, err)
+
+		for length := 0; length < len(buffer); length++ {
+			err := (&multicastPacket{}).UnmarshalBinary(buffer[:length])
+			assert.True(t, errors.Is(err, ErrTruncatedPacket), "length %d: %v", length, err)
+		}
+	})
+
+	t.Run("KeyTooLarge", func(t *testing.T) {
+		mp := &multicastPacket{key: make([]byte, maximalKeySize+1), time: time.Now()}
+		_, err := mp.MarshalBinary()
+		assert.True(t, errors.Is(err, ErrKeyTooLarge))
+	})
+
+	t.Run("LargeValue", func(t *testing.T) {
+		value := bytes.Repeat([]byte("x"), math.MaxUint16+1)
+		mp := &multicastPacket{key: []byte("key"), time: time.Now(), value: value}
+		buffer, err := mp.MarshalBinary()
+		require.NoError(t, err)
+		assert.Equal(t, flagLargeValue, buffer[3])
+
+		result := &multicastPacket{}
+		require.NoError(t, result.UnmarshalBinary(buffer))
+		assert.Equal(t, value, result.value)
+
+		err = (&multicastPacket{}).UnmarshalBinary(buffer[:len(buffer)-1])
+		assert.True(t, errors.Is(err, ErrTruncatedPacket))
+	})
+}
+
+func FuzzMulticastPacket(f *testing.F) {
+	now := time.Unix(1400000000, 0)
+	mp := &multicastPacket{key: []byte("key"), time: now, value: []byte("value")}
+	buffer, _ := mp.MarshalBinary()
+	f.Add(buffer)
+	f.Add(marshalVersionZeroPacket([]byte("key"), now, []byte("value")))
+	f.Add([]byte{multicastPacketMarker})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, buffer []byte) {
+		mp := &multicastPacket{}
+		if err := mp.UnmarshalBinary(buffer); err != nil {
+			return
+		}
+
+		marshaled, err := mp.MarshalBinary()
+		if err != nil {
+			t.Fatalf("marshal decoded packet: %v", err)
+		}
+
+		result := &multicastPacket{}
+		if err := result.UnmarshalBinary(marshaled); err != nil {
+			t.Fatalf("unmarshal marshaled packet: %v", err)
+		}
+		if result.operation != mp.operation || result.flags != mp.flags ||
+			string(result.key) != string(mp.key) || !result.time.Equal(mp.time) ||
+			string(result.value) != string(mp.value) {
+			t.Fatalf("round trip mismatch: %+v != %+v", result, mp)
+		}
+	})
+}
@@ -0,0 +1,323 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Each node periodically sends a heartbeat, that carries its label, item count and protocol
+// version. A peer that hasn't been heard of for a few heartbeat intervals is suspected, and
+// after some more intervals it's considered dead. Dead peers are kept in the peer table for
+// a grace period before they are removed. A node that is closed sends a final heartbeat with
+// the leaving flag, so its peers consider it dead right away.
+const (
+	flagLeaving uint8 = 1 << 1
+
+	heartbeatProtocolVersion = 1
+
+	defaultHeartbeatInterval = time.Second
+	peerSuspectedAfter       = 3
+	peerDeadAfter            = 6
+	peerRemovedAfter         = 12
+)
+
+// PeerState defines the liveness of a peer.
+type PeerState int
+
+// The states of a peer.
+const (
+	PeerAlive PeerState = iota
+	PeerSuspected
+	PeerDead
+)
+
+func (s PeerState) String() string {
+	switch s {
+	case PeerAlive:
+		return "alive"
+	case PeerSuspected:
+		return "suspected"
+	case PeerDead:
+		return "dead"
+	default:
+		return fmt.Sprintf("PeerState(%d)", int(s))
+	}
+}
+
+// PeerEvent defines a change in the membership of the group.
+type PeerEvent int
+
+// The events of the peer table.
+const (
+	PeerJoined PeerEvent = iota
+	PeerLeft
+	PeerStateChanged
+)
+
+func (e PeerEvent) String() string {
+	switch e {
+	case PeerJoined:
+		return "joined"
+	case PeerLeft:
+		return "left"
+	case PeerStateChanged:
+		return "state changed"
+	default:
+		return fmt.Sprintf("PeerEvent(%d)", int(e))
+	}
+}
+
+// Peer contains the information about another node of the group. The protocol version is
+// announced in the peer's heartbeats and is zero, if the peer doesn't announce one.
+type Peer struct {
+	ID              []byte
+	Label           string
+	ProtocolVersion int
+	Items           int
+	LastSeen        time.Time
+	State           PeerState
+}
+
+// PeerHandler defines a handler for changes in the peer table.
+type PeerHandler func(PeerEvent, Peer)
+
+type peerTable struct {
+	mutex   sync.Mutex
+	peers   map[string]*Peer
+	handler PeerHandler
+	events  []peerEvent
+}
+
+type peerEvent struct {
+	event PeerEvent
+	peer  Peer
+}
+
+func newPeerTable() *peerTable {
+	return &peerTable{
+		peers: make(map[string]*Peer),
+	}
+}
+
+// SetLabel sets the label that is sent with the heartbeats. It defaults to the hostname.
+func (m *Multicast) SetLabel(label string) {
+	m.label.Store(label)
+}
+
+// SetHeartbeatInterval sets the interval in which heartbeats are sent. Peers are suspected
+// after three intervals without any packet, considered dead after six and removed after
+// twelve. The default is one second.
+func (m *Multicast) SetHeartbeatInterval(interval time.Duration) {
+	m.heartbeatInterval.Store(int64(interval))
+	select {
+	case m.heartbeatReset <- struct{}{}:
+	default:
+	}
+}
+
+// SetPeerHandler sets a function that is called every time a peer joins or leaves the group
+// or changes its state. The function is called synchronously and should return quickly.
+func (m *Multicast) SetPeerHandler(handler PeerHandler) {
+	m.peers.mutex.Lock()
+	m.peers.handler = handler
+	m.peers.mutex.Unlock()
+}
+
+// Peers returns all known peers ordered by their ids, including the dead ones, that
+// haven't been removed yet.
+func (m *Multicast) Peers() []Peer {
+	return m.peers.list()
+}
+
+func (m *Multicast) heartbeatLoop() {
+	for {
+		interval := time.Duration(m.heartbeatInterval.Load())
+		if interval <= 0 {
+			interval = defaultHeartbeatInterval
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-m.heartbeatReset:
+			continue
+		case <-m.done:
+			return
+		}
+
+		if err := m.sendHeartbeat(); err != nil && !errors.Is(err, ErrClosed) {
+			m.logger.Printf("send heartbeat: %v", err)
+		}
+		m.peers.check(m.now(), interval)
+	}
+}
+
+func (m *Multicast) sendHeartbeat() error {
+	if err := m.send(m.heartbeat(0)); err != nil {
+		return err
+	}
+	m.counters.heartbeatsSent.Add(1)
+
+	return m.Flush()
+}
+
+// sendLeavingHeartbeat flushes the pending packets, followed by a heartbeat with the
+// leaving flag. Afterwards, no more packets are sent.
+func (m *Multicast) sendLeavingHeartbeat() error {
+	m.sendMutex.Lock()
+	defer m.sendMutex.Unlock()
+	m.sendClosed = true
+
+	if err := m.sendLocked(m.heartbeat(flagLeaving)); err != nil {
+		return err
+	}
+	m.counters.heartbeatsSent.Add(1)
+
+	if m.transmitter.Buffered() == 0 {
+		return nil
+	}
+	return m.flush()
+}
+
+func (m *Multicast) heartbeat(flags uint8) *multicastPacket {
+	value := binary.BigEndian.AppendUint64(nil, uint64(m.parent.Size()))
+	value = binary.BigEndian.AppendUint16(value, heartbeatProtocolVersion)
+
+	return &multicastPacket{
+		operation: operationHeartbeat,
+		flags:     flags,
+		key:       []byte(m.label.Load().(string)),
+		time:      m.now(),
+		value:     value,
+	}
+}
+
+func (m *Multicast) handleHeartbeat(sender []byte, mp *multicastPacket) {
+	m.counters.heartbeatsReceived.Add(1)
+
+	if mp.flags&flagLeaving != 0 {
+		m.peers.leave(sender)
+		return
+	}
+
+	items, version := 0, 0
+	if len(mp.value) >= 8 {
+		items = int(binary.BigEndian.Uint64(mp.value[:8]))
+	}
+	if len(mp.value) >= 10 {
+		version = int(binary.BigEndian.Uint16(mp.value[8:10]))
+	}
+	m.peers.update(sender, m.now(), func(peer *Peer) {
+		peer.Label = string(mp.key)
+		peer.ProtocolVersion = version
+		peer.Items = items
+	})
+}
+
+// update marks the peer with the given id as seen, adds it to the table if it's unknown
+// and applies the given function to it. A dead peer, that is seen again, joins anew.
+func (t *peerTable) update(id []byte, now time.Time, f func(*Peer)) {
+	t.mutex.Lock()
+	defer t.dispatch()
+
+	peer, found := t.peers[string(id)]
+	if !found {
+		peer = &Peer{ID: append([]byte{}, id...)}
+		t.peers[string(id)] = peer
+	}
+	peer.LastSeen = now
+	if f != nil {
+		f(peer)
+	}
+
+	switch {
+	case !found || peer.State == PeerDead:
+		peer.State = PeerAlive
+		t.notify(PeerJoined, peer)
+	case peer.State != PeerAlive:
+		peer.State = PeerAlive
+		t.notify(PeerStateChanged, peer)
+	}
+}
+
+// leave marks the peer with the given id as dead.
+func (t *peerTable) leave(id []byte) {
+	t.mutex.Lock()
+	defer t.dispatch()
+
+	if peer, found := t.peers[string(id)]; found && peer.State != PeerDead {
+		peer.State = PeerDead
+		t.notify(PeerLeft, peer)
+	}
+}
+
+// check updates the states of all peers according to the time they have last been seen
+// and removes the peers, that have been dead for the grace period.
+func (t *peerTable) check(now time.Time, interval time.Duration) {
+	t.mutex.Lock()
+	defer t.dispatch()
+
+	for id, peer := range t.peers {
+		silence := now.Sub(peer.LastSeen)
+		switch {
+		case silence > peerRemovedAfter*interval:
+			delete(t.peers, id)
+		case silence > peerDeadAfter*interval && peer.State != PeerDead:
+			peer.State = PeerDead
+			t.notify(PeerLeft, peer)
+		case silence > peerSuspectedAfter*interval && peer.State == PeerAlive:
+			peer.State = PeerSuspected
+			t.notify(PeerStateChanged, peer)
+		}
+	}
+}
+
+func (t *peerTable) list() []Peer {
+	t.mutex.Lock()
+	peers := make([]Peer, 0, len(t.peers))
+	for _, peer := range t.peers {
+		peers = append(peers, *peer)
+	}
+	t.mutex.Unlock()
+
+	sort.Slice(peers, func(i, j int) bool { return bytes.Compare(peers[i].ID, peers[j].ID) < 0 })
+	return peers
+}
+
+// notify must be called with the mutex being held. The event is passed to the handler
+// by dispatch.
+func (t *peerTable) notify(event PeerEvent, peer *Peer) {
+	if t.handler != nil {
+		t.events = append(t.events, peerEvent{event, *peer})
+	}
+}
+
+// dispatch releases the mutex and passes all collected events to the handler, so that
+// the handler can access the peer table.
+func (t *peerTable) dispatch() {
+	handler, events := t.handler, t.events
+	t.events = nil
+	t.mutex.Unlock()
+
+	for _, e := range events {
+		handler(e.event, e.peer)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+)
+
+var (
+	// ErrNoSignatures is returned if the trusted keys are set on a fader, that doesn't sign
+	// its frames.
+	ErrNoSignatures = errors.New("signatures not enabled")
+
+	// ErrUntrustedSender is returned if a signed frame is received from a sender, whose
+	// public key isn't trusted.
+	ErrUntrustedSender = errors.New("untrusted sender")
+
+	// ErrInvalidSignature is returned if the signature of a frame doesn't match.
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// SignatureConfig configures the signing of frames. Each node signs its frames with its
+// private key. Receivers only accept frames, whose signature can be verified with the
+// public key, that is trusted for the sender's id. Since the signatures are placed in the
+// encrypted frames, all nodes of a group must either sign their frames or not.
+//
+// Items, that are sent in response to sync or range requests, are signed by the
+// responding node, since the original frames are not kept. Trusting a node therefore
+// includes trusting the items it relays.
+type SignatureConfig struct {
+	PrivateKey  ed25519.PrivateKey
+	TrustedKeys []TrustedKey
+}
+
+// TrustedKey binds a public key to the id of a node.
+type TrustedKey struct {
+	Sender    []byte
+	PublicKey ed25519.PublicKey
+}
+
+// WithSignatures enables the signing of frames. See SignatureConfig.
+func WithSignatures(config SignatureConfig) MulticastOption {
+	return multicastOption(func(o *multicastOptions) error {
+		if len(config.PrivateKey) != ed25519.PrivateKeySize {
+			return fmt.Errorf("private key of length %d: %w", len(config.PrivateKey), ErrInvalidOption)
+		}
+		trustedKeys, err := newTrustedKeys(config.TrustedKeys)
+		if err != nil {
+			return err
+		}
+
+		s := &signer{privateKey: config.PrivateKey}
+		s.trustedKeys.Store(&trustedKeys)
+		o.signer = s
+		return nil
+	})
+}
+
+// SetTrustedKeys replaces the trusted public keys. If the fader doesn't sign its frames,
+// ErrNoSignatures is returned.
+func (m *Multicast) SetTrustedKeys(keys []TrustedKey) error {
+	if m.transmitter.signer == nil {
+		return ErrNoSignatures
+	}
+	trustedKeys, err := newTrustedKeys(keys)
+	if err != nil {
+		return err
+	}
+	m.transmitter.signer.trustedKeys.Store(&trustedKeys)
+	return nil
+}
+
+func newTrustedKeys(keys []TrustedKey) (map[string]ed25519.PublicKey, error) {
+	trustedKeys := make(map[string]ed25519.PublicKey)
+	for _, key := range keys {
+		if len(key.Sender) != idSize {
+			return nil, fmt.Errorf("trusted sender id of length %d: %w", len(key.Sender), ErrInvalidOption)
+		}
+		if len(key.PublicKey) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("public key of length %d: %w", len(key.PublicKey), ErrInvalidOption)
+		}
+		trustedKeys[string(key.Sender)] = key.PublicKey
+	}
+	return trustedKeys, nil
+}
+
+// signer signs the outgoing and verifies the incoming frames. A signed frame carries the
+// signature between the sender's id and the packets. The signature covers the id, the
+// nonce and the packets, so a signed payload can't be replayed with another nonce.
+type signer struct {
+	privateKey  ed25519.PrivateKey
+	trustedKeys atomic.Pointer[map[string]ed25519.PublicKey]
+}
+
+// sign returns the plain text of a frame with the given payload.
+func (s *signer) sign(id []byte, nonce *big.Int, payload []byte) []byte {
+	signature := ed25519.Sign(s.privateKey, signedMessage(id, nonce, payload))
+
+	plainText := make([]byte, 0, idSize+ed25519.SignatureSize+len(payload))
+	plainText = append(plainText, id...)
+	plainText = append(plainText, signature...)
+	return append(plainText, payload...)
+}
+
+// verify checks the signature after the id of the plain text and returns the payload.
+func (s *signer) verify(id []byte, nonce *big.Int, plainText []byte) ([]byte, error) {
+	if len(plainText) < idSize+ed25519.SignatureSize {
+		return nil, fmt.Errorf("signed packet of size %d: %w", len(plainText), ErrTruncatedPacket)
+	}
+	signature := plainText[idSize : idSize+ed25519.SignatureSize]
+	payload := plainText[idSize+ed25519.SignatureSize:]
+
+	publicKey, found := (*s.trustedKeys.Load())[string(id)]
+	if !found {
+		return nil, fmt.Errorf("sender %x: %w", id, ErrUntrustedSender)
+	}
+	if !ed25519.Verify(publicKey, signedMessage(id, nonce, payload), signature) {
+		return nil, fmt.Errorf("sender %x: %w", id, ErrInvalidSignature)
+	}
+	return payload, nil
+}
+
+func signedMessage(id []byte, nonce *big.Int, payload []byte) []byte {
+	nonceBytes := nonce.Bytes()
+	message := make([]byte, 0, idSize+1+len(nonceBytes)+len(payload))
+	message = append(message, id...)
+	message = append(message, byte(len(nonceBytes)))
+	message = append(message, nonceBytes...)
+	return append(message, payload...)
+}
@@ -0,0 +1,149 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package fader_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/posteo/fader"
+)
+
+func signingKey(seed byte) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+}
+
+func verifyingKey(seed byte) ed25519.PublicKey {
+	return signingKey(seed).Public().(ed25519.PublicKey)
+}
+
+func withSigningKey(seed byte, trustedKeys ...fader.TrustedKey) fader.MulticastOption {
+	return fader.WithSignatures(fader.SignatureConfig{PrivateKey: signingKey(seed), TrustedKeys: trustedKeys})
+}
+
+func TestMulticastSignatures(t *testing.T) {
+	bus := fader.NewBus()
+	errs := make(chan *fader.ReceiveError, 10)
+	receiver, parent := setUpBusFader(t, bus, "receiver", multicastFaderIDOne,
+		withSigningKey(1, fader.TrustedKey{Sender: multicastFaderIDTwo, PublicKey: verifyingKey(2)}),
+		fader.WithErrorHandler(func(err *fader.ReceiveError) bool {
+			errs <- err
+			return true
+		}))
+
+	// drain returns the errors of the last packets. Heartbeats are rejected like items.
+	drain := func() []*fader.ReceiveError {
+		time.Sleep(10 * time.Millisecond)
+		result := []*fader.ReceiveError{}
+		for len(errs) > 0 {
+			result = append(result, <-errs)
+		}
+		return result
+	}
+
+	t.Run("Trusted", func(t *testing.T) {
+		two, _ := setUpBusFader(t, bus, "two", multicastFaderIDTwo, withSigningKey(2))
+
+		require.NoError(t, two.Put([]byte("key"), time.Now(), []byte("value")))
+		time.Sleep(10 * time.Millisecond)
+
+		_, value := parent.Get([]byte("key"))
+		assert.Equal(t, []byte("value"), value)
+		assert.Empty(t, errs)
+	})
+
+	t.Run("Untrusted", func(t *testing.T) {
+		three, _ := setUpBusFader(t, bus, "three", multicastFaderIDThree, withSigningKey(3))
+
+		require.NoError(t, three.Put([]byte("untrusted"), time.Now(), []byte("value")))
+
+		receiveErrs := drain()
+		require.NotEmpty(t, receiveErrs)
+		for _, err := range receiveErrs {
+			assert.Equal(t, fader.ReceiveErrorSignature, err.Kind)
+			assert.True(t, errors.Is(err, fader.ErrUntrustedSender))
+		}
+
+		require.NoError(t, receiver.SetTrustedKeys([]fader.TrustedKey{
+			{Sender: multicastFaderIDTwo, PublicKey: verifyingKey(2)},
+			{Sender: multicastFaderIDThree, PublicKey: verifyingKey(3)},
+		}))
+		require.NoError(t, three.Put([]byte("trusted"), time.Now(), []byte("value")))
+		time.Sleep(10 * time.Millisecond)
+
+		_, value := parent.Get([]byte("trusted"))
+		assert.Equal(t, []byte("value"), value)
+		assert.Empty(t, drain())
+	})
+
+	t.Run("Impostor", func(t *testing.T) {
+		impostor, _ := setUpBusFader(t, bus, "impostor", multicastFaderIDTwo, withSigningKey(4))
+
+		require.NoError(t, impostor.Put([]byte("forged"), time.Now(), []byte("value")))
+
+		receiveErrs := drain()
+		require.NotEmpty(t, receiveErrs)
+		for _, err := range receiveErrs {
+			assert.Equal(t, multicastFaderIDTwo, err.Sender)
+			assert.True(t, errors.Is(err, fader.ErrInvalidSignature))
+		}
+		_, value := parent.Get([]byte("forged"))
+		assert.Nil(t, value)
+	})
+
+	t.Run("Unsigned", func(t *testing.T) {
+		unsigned, _ := setUpBusFader(t, bus, "unsigned", multicastFaderIDTwo)
+
+		require.NoError(t, unsigned.Put([]byte("unsigned"), time.Now(), []byte("value")))
+
+		receiveErrs := drain()
+		require.NotEmpty(t, receiveErrs)
+		for _, err := range receiveErrs {
+			assert.Equal(t, fader.ReceiveErrorSignature, err.Kind)
+		}
+		_, value := parent.Get([]byte("unsigned"))
+		assert.Nil(t, value)
+		assert.True(t, errors.Is(unsigned.SetTrustedKeys(nil), fader.ErrNoSignatures))
+		assert.True(t, receiver.Stats().SignatureErrors >= 3)
+	})
+	t.Run("Sync", func(t *testing.T) {
+		bus := fader.NewBus()
+		joining := fader.TrustedKey{Sender: multicastFaderIDOne, PublicKey: verifyingKey(1)}
+		two, parentTwo := setUpBusFader(t, bus, "two", multicastFaderIDTwo, withSigningKey(2, joining))
+		require.NoError(t, parentTwo.Put([]byte("two"), time.Now(), []byte("value")))
+		three, parentThree := setUpBusFader(t, bus, "three", multicastFaderIDThree, withSigningKey(3, joining))
+		require.NoError(t, parentThree.Put([]byte("three"), time.Now(), []byte("value")))
+
+		// The items are relayed with the responder's signature, so only the ones of the
+		// trusted responder are accepted.
+		_, parent := setUpBusFader(t, bus, "joining", multicastFaderIDOne,
+			withSigningKey(1, fader.TrustedKey{Sender: multicastFaderIDTwo, PublicKey: verifyingKey(2)}),
+			fader.WithErrorHandler(func(*fader.ReceiveError) bool { return true }),
+			fader.WithSyncOnJoin())
+		time.Sleep(100 * time.Millisecond)
+
+		_, value := parent.Get([]byte("two"))
+		assert.Equal(t, []byte("value"), value)
+		_, value = parent.Get([]byte("three"))
+		assert.Nil(t, value)
+		assert.Equal(t, uint64(1), two.Stats().SyncItemsSent)
+		assert.Equal(t, uint64(1), three.Stats().SyncItemsSent)
+	})
+}
@@ -0,0 +1,130 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+var (
+	// ErrNoMulticastAddress is returned if the address of a multicast transport isn't
+	// a multicast group.
+	ErrNoMulticastAddress = errors.New("no multicast address")
+
+	// ErrUnknownInterface is returned if no network interface matches the configured
+	// name or address.
+	ErrUnknownInterface = errors.New("unknown interface")
+)
+
+// MulticastTransportConfig contains the socket options of a multicast transport. The zero
+// value keeps the defaults of the operating system.
+type MulticastTransportConfig struct {
+	// Interface is the name or an address of the network interface, on which the group is
+	// joined and the packets are sent. Link-local IPv6 groups (ff02::/16) usually require
+	// an interface to be set.
+	Interface string
+
+	// TTL is the time-to-live of IPv4 packets or the hop limit of IPv6 packets. The default
+	// of 1 keeps the packets within the local network.
+	TTL int
+
+	// DisableLoopback prevents that the packets are delivered to other nodes of the group
+	// on the same host.
+	DisableLoopback bool
+
+	// ReceiveBufferSize sets the size of the socket's receive buffer. A larger buffer
+	// drops fewer packets during bursts.
+	ReceiveBufferSize int
+}
+
+// networkInterface returns the interface with the configured name or address. If no
+// interface is configured, nil is returned, which selects the default interface.
+func (c MulticastTransportConfig) networkInterface() (*net.Interface, error) {
+	if c.Interface == "" {
+		return nil, nil
+	}
+
+	if iface, err := net.InterfaceByName(c.Interface); err == nil {
+		return iface, nil
+	}
+
+	ip := net.ParseIP(c.Interface)
+	if ip == nil {
+		return nil, fmt.Errorf("interface [%s]: %w", c.Interface, ErrUnknownInterface)
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("list interfaces: %w", err)
+	}
+	for index := range ifaces {
+		addresses, err := ifaces[index].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			if ipNet, ok := address.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return &ifaces[index], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("interface [%s]: %w", c.Interface, ErrUnknownInterface)
+}
+
+// apply sets the options for outgoing packets on the connection.
+func (c MulticastTransportConfig) apply(connection *net.UDPConn, network string, iface *net.Interface) error {
+	if network == "udp4" {
+		packetConnection := ipv4.NewPacketConn(connection)
+		if iface != nil {
+			if err := packetConnection.SetMulticastInterface(iface); err != nil {
+				return fmt.Errorf("set multicast interface: %w", err)
+			}
+		}
+		if c.TTL > 0 {
+			if err := packetConnection.SetMulticastTTL(c.TTL); err != nil {
+				return fmt.Errorf("set multicast ttl: %w", err)
+			}
+		}
+		if c.DisableLoopback {
+			if err := packetConnection.SetMulticastLoopback(false); err != nil {
+				return fmt.Errorf("disable multicast loopback: %w", err)
+			}
+		}
+		return nil
+	}
+
+	packetConnection := ipv6.NewPacketConn(connection)
+	if iface != nil {
+		if err := packetConnection.SetMulticastInterface(iface); err != nil {
+			return fmt.Errorf("set multicast interface: %w", err)
+		}
+	}
+	if c.TTL > 0 {
+		if err := packetConnection.SetMulticastHopLimit(c.TTL); err != nil {
+			return fmt.Errorf("set multicast hop limit: %w", err)
+		}
+	}
+	if c.DisableLoopback {
+		if err := packetConnection.SetMulticastLoopback(false); err != nil {
+			return fmt.Errorf("disable multicast loopback: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/posteo/fader"
+)
+
+func receiveFrame(t *testing.T, transport fader.Transport) string {
+	received := make(chan string, 1)
+	go func() {
+		buffer := make([]byte, 64)
+		n, _, err := transport.Receive(buffer)
+		if err == nil {
+			received <- string(buffer[:n])
+		}
+	}()
+
+	select {
+	case frame := <-received:
+		return frame
+	case <-time.After(time.Second):
+		t.Fatal("no frame received")
+		return ""
+	}
+}
+
+func multicastInterface() *net.Interface {
+	ifaces, _ := net.Interfaces()
+	for index := range ifaces {
+		if ifaces[index].Flags&(net.FlagUp|net.FlagMulticast) == net.FlagUp|net.FlagMulticast {
+			return &ifaces[index]
+		}
+	}
+	return nil
+}
+
+func TestMulticastTransportConfig(t *testing.T) {
+	t.Run("NoMulticastAddress", func(t *testing.T) {
+		_, err := fader.NewMulticastTransport("127.0.0.1:2003")
+		assert.True(t, errors.Is(err, fader.ErrNoMulticastAddress))
+	})
+
+	t.Run("UnknownInterface", func(t *testing.T) {
+		_, err := fader.NewMulticastTransportWithConfig("224.0.0.1:2003", fader.MulticastTransportConfig{Interface: "unknown0"})
+		assert.True(t, errors.Is(err, fader.ErrUnknownInterface))
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		config := fader.MulticastTransportConfig{TTL: 2, ReceiveBufferSize: 1 << 20}
+		sender, err := fader.NewMulticastTransportWithConfig("224.0.0.1:2003", config)
+		require.NoError(t, err)
+		defer sender.Close()
+		receiver, err := fader.NewMulticastTransportWithConfig("224.0.0.1:2003", config)
+		require.NoError(t, err)
+		defer receiver.Close()
+
+		require.NoError(t, sender.Send([]byte("frame")))
+		assert.Equal(t, "frame", receiveFrame(t, receiver))
+	})
+
+	t.Run("IPv6", func(t *testing.T) {
+		iface := multicastInterface()
+		if iface == nil {
+			t.Skip("no multicast interface")
+		}
+		config := fader.MulticastTransportConfig{Interface: iface.Name}
+
+		sender, err := fader.NewMulticastTransportWithConfig("[ff02::fade]:2003", config)
+		if err != nil {
+			t.Skipf("no IPv6 multicast: %v", err)
+		}
+		defer sender.Close()
+		receiver, err := fader.NewMulticastTransportWithConfig("[ff02::fade]:2003", config)
+		require.NoError(t, err)
+		defer receiver.Close()
+
+		require.NoError(t, sender.Send([]byte("frame")))
+		assert.Equal(t, "frame", receiveFrame(t, receiver))
+	})
+}
@@ -0,0 +1,156 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import "sync/atomic"
+
+// MulticastStats contains the counters of a multicast fader.
+type MulticastStats struct {
+	PacketsSent           uint64
+	PacketsReceived       uint64
+	DatagramsSent         uint64
+	DatagramsReceived     uint64
+	UnknownVersions       uint64
+	UnhandledOperations   uint64
+	UnknownChannels       uint64
+	FragmentsSent         uint64
+	FragmentsReceived     uint64
+	Reassembled           uint64
+	ReassembliesTimedOut  uint64
+	ReassembliesDropped   uint64
+	SyncRequestsSent      uint64
+	SyncRequestsReceived  uint64
+	SyncRequestsIgnored   uint64
+	SyncItemsSent         uint64
+	SyncItemsReceived     uint64
+	SyncDuplicates        uint64
+	DigestsSent           uint64
+	DigestsReceived       uint64
+	RangeRequestsSent     uint64
+	RangeRequestsReceived uint64
+	RangeRequestsIgnored  uint64
+	RangeItemsSent        uint64
+	ClearsReceived        uint64
+	StalePuts             uint64
+	HeartbeatsSent        uint64
+	HeartbeatsReceived    uint64
+	TransportErrors       uint64
+	DroppedFrames         uint64
+	DecryptErrors         uint64
+	SignatureErrors       uint64
+	ReplayedPackets       uint64
+	DecodeErrors          uint64
+	ParentErrors          uint64
+	RejectedSenders       uint64
+	RejectedSources       uint64
+	RejectedPackets       uint64
+	FramesLimited         uint64
+	SenderFramesLimited   uint64
+	BannedFrames          uint64
+	SenderBans            uint64
+	PutsLimited           uint64
+}
+
+type multicastCounters struct {
+	packetsSent           atomic.Uint64
+	packetsReceived       atomic.Uint64
+	datagramsSent         atomic.Uint64
+	datagramsReceived     atomic.Uint64
+	unknownVersions       atomic.Uint64
+	unhandledOperations   atomic.Uint64
+	unknownChannels       atomic.Uint64
+	fragmentsSent         atomic.Uint64
+	fragmentsReceived     atomic.Uint64
+	reassembled           atomic.Uint64
+	reassembliesTimedOut  atomic.Uint64
+	reassembliesDropped   atomic.Uint64
+	syncRequestsSent      atomic.Uint64
+	syncRequestsReceived  atomic.Uint64
+	syncRequestsIgnored   atomic.Uint64
+	syncItemsSent         atomic.Uint64
+	syncItemsReceived     atomic.Uint64
+	syncDuplicates        atomic.Uint64
+	digestsSent           atomic.Uint64
+	digestsReceived       atomic.Uint64
+	rangeRequestsSent     atomic.Uint64
+	rangeRequestsReceived atomic.Uint64
+	rangeRequestsIgnored  atomic.Uint64
+	rangeItemsSent        atomic.Uint64
+	clearsReceived        atomic.Uint64
+	stalePuts             atomic.Uint64
+	heartbeatsSent        atomic.Uint64
+	heartbeatsReceived    atomic.Uint64
+	transportErrors       atomic.Uint64
+	decryptErrors         atomic.Uint64
+	signatureErrors       atomic.Uint64
+	replayedPackets       atomic.Uint64
+	decodeErrors          atomic.Uint64
+	parentErrors          atomic.Uint64
+	rejectedSenders       atomic.Uint64
+	rejectedSources       atomic.Uint64
+	rejectedPackets       atomic.Uint64
+	framesLimited         atomic.Uint64
+	senderFramesLimited   atomic.Uint64
+	bannedFrames          atomic.Uint64
+	senderBans            atomic.Uint64
+	putsLimited           atomic.Uint64
+}
+
+func (c *multicastCounters) stats() MulticastStats {
+	return MulticastStats{
+		PacketsSent:           c.packetsSent.Load(),
+		PacketsReceived:       c.packetsReceived.Load(),
+		DatagramsSent:         c.datagramsSent.Load(),
+		DatagramsReceived:     c.datagramsReceived.Load(),
+		UnknownVersions:       c.unknownVersions.Load(),
+		UnhandledOperations:   c.unhandledOperations.Load(),
+		UnknownChannels:       c.unknownChannels.Load(),
+		FragmentsSent:         c.fragmentsSent.Load(),
+		FragmentsReceived:     c.fragmentsReceived.Load(),
+		Reassembled:           c.reassembled.Load(),
+		ReassembliesTimedOut:  c.reassembliesTimedOut.Load(),
+		ReassembliesDropped:   c.reassembliesDropped.Load(),
+		SyncRequestsSent:      c.syncRequestsSent.Load(),
+		SyncRequestsReceived:  c.syncRequestsReceived.Load(),
+		SyncRequestsIgnored:   c.syncRequestsIgnored.Load(),
+		SyncItemsSent:         c.syncItemsSent.Load(),
+		SyncItemsReceived:     c.syncItemsReceived.Load(),
+		SyncDuplicates:        c.syncDuplicates.Load(),
+		DigestsSent:           c.digestsSent.Load(),
+		DigestsReceived:       c.digestsReceived.Load(),
+		RangeRequestsSent:     c.rangeRequestsSent.Load(),
+		RangeRequestsReceived: c.rangeRequestsReceived.Load(),
+		RangeRequestsIgnored:  c.rangeRequestsIgnored.Load(),
+		RangeItemsSent:        c.rangeItemsSent.Load(),
+		ClearsReceived:        c.clearsReceived.Load(),
+		StalePuts:             c.stalePuts.Load(),
+		HeartbeatsSent:        c.heartbeatsSent.Load(),
+		HeartbeatsReceived:    c.heartbeatsReceived.Load(),
+		TransportErrors:       c.transportErrors.Load(),
+		DecryptErrors:         c.decryptErrors.Load(),
+		SignatureErrors:       c.signatureErrors.Load(),
+		ReplayedPackets:       c.replayedPackets.Load(),
+		DecodeErrors:          c.decodeErrors.Load(),
+		ParentErrors:          c.parentErrors.Load(),
+		RejectedSenders:       c.rejectedSenders.Load(),
+		RejectedSources:       c.rejectedSources.Load(),
+		RejectedPackets:       c.rejectedPackets.Load(),
+		FramesLimited:         c.framesLimited.Load(),
+		SenderFramesLimited:   c.senderFramesLimited.Load(),
+		BannedFrames:          c.bannedFrames.Load(),
+		SenderBans:            c.senderBans.Load(),
+		PutsLimited:           c.putsLimited.Load(),
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import (
+	"bytes"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// Items that are sent in response to a sync request are flagged, so the receivers can
+// drop the ones they already have.
+const (
+	flagSync uint8 = 1 << 0
+
+	defaultSyncRate     = 1000
+	syncMinimalInterval = time.Second
+	syncMaximalDelay    = 50 * time.Millisecond
+	syncBatchInterval   = 10 * time.Millisecond
+)
+
+// RequestSync asks the peers of the group to send their live items. It's meant to be called
+// right after a node joined the group, so that its parent fader converges quickly instead
+// of missing all items that have been put before. The items arrive asynchronously.
+// Peers only respond, if their parent fader implements Ranger. See WithSyncOnJoin to send
+// the request automatically.
+func (m *Multicast) RequestSync() error {
+	if err := m.send(&multicastPacket{operation: operationSyncRequest, time: m.now()}); err != nil {
+		return err
+	}
+	m.counters.syncRequestsSent.Add(1)
+
+	return nil
+}
+
+// SetSyncRate sets the number of items per second that are sent in response to a sync
+// request. The default is 1000.
+func (m *Multicast) SetSyncRate(rate int) {
+	m.syncRate.Store(int64(rate))
+}
+
+// handleSyncRequest starts a response in the background. Only one response is sent at a
+// time and at most one per second, no matter how many nodes are requesting.
+func (m *Multicast) handleSyncRequest() {
+	m.counters.syncRequestsReceived.Add(1)
+
+	ranger, ok := m.parent.(Ranger)
+	if !ok {
+		m.counters.syncRequestsIgnored.Add(1)
+		return
+	}
+
+	now := m.now().UnixNano()
+	if now-m.lastSyncResponse.Load() < int64(syncMinimalInterval) || !m.syncResponding.CompareAndSwap(false, true) {
+		m.counters.syncRequestsIgnored.Add(1)
+		return
+	}
+	m.lastSyncResponse.Store(now)
+
+	m.goroutines.Go(func() { m.respondSync(ranger) })
+}
+
+// respondSync sends all items of the ranger. A random delay before the first batch keeps
+// the peers from responding in lockstep.
+func (m *Multicast) respondSync(ranger Ranger) {
+	defer m.syncResponding.Store(false)
+
+	items := []item{}
+	ranger.Range(func(key []byte, t time.Time, value []byte) bool {
+		items = append(items, item{key: key, time: t, value: value})
+		return true
+	})
+
+	select {
+	case <-time.After(rand.N(syncMaximalDelay)):
+	case <-m.done:
+		return
+	}
+
+	sent := m.sendItems(items, int(m.syncRate.Load()))
+	m.counters.syncItemsSent.Add(uint64(sent))
+}
+
+// sendItems sends the items as flagged puts in batches, so that no more than the given
+// number of items is sent per second. It returns the number of sent items.
+func (m *Multicast) sendItems(items []item, rate int) int {
+	if rate < 1 {
+		rate = 1
+	}
+	batchSize, batchInterval := rate*int(syncBatchInterval)/int(time.Second), syncBatchInterval
+	if batchSize < 1 {
+		batchSize, batchInterval = 1, time.Second/time.Duration(rate)
+	}
+
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+
+	for index, item := range items {
+		if index > 0 && index%batchSize == 0 {
+			select {
+			case <-ticker.C:
+			case <-m.done:
+				return index
+			}
+		}
+
+		mp := &multicastPacket{
+			operation: operationPut,
+			flags:     flagSync,
+			key:       item.key,
+			time:      item.time,
+			value:     item.value,
+		}
+		if err := m.send(mp); err != nil {
+			if !errors.Is(err, ErrClosed) {
+				m.logger.Printf("send item: %v", err)
+			}
+			return index
+		}
+	}
+
+	if err := m.Flush(); err != nil {
+		m.logger.Printf("flush items: %v", err)
+	}
+	return len(items)
+}
+
+// contains returns true if the parent fader holds an item with the given key, time and value.
+func (m *Multicast) contains(key []byte, t time.Time, value []byte) bool {
+	times, values := m.parent.Select(key)
+	for index := range times {
+		if times[index].Equal(t) && bytes.Equal(values[index], value) {
+			return true
+		}
+	}
+	return false
+}
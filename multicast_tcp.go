@@ -0,0 +1,413 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The tcp transport keeps a persistent connection to each peer, on which the encrypted
+// frames are written with a length prefix. Each peer has a bounded write queue, that
+// buffers the frames while the connection is reestablished.
+const (
+	tcpFrameHeaderSize   = 4
+	tcpIncomingQueueSize = 1024
+	defaultTCPQueueSize  = 1024
+	tcpDialTimeout       = 5 * time.Second
+	tcpWriteTimeout      = 10 * time.Second
+	tcpDrainTimeout      = time.Second
+	tcpMinimalBackoff    = 100 * time.Millisecond
+	tcpMaximalBackoff    = 10 * time.Second
+)
+
+// ErrFrameTooLarge is returned if a frame exceeds the maximal datagram size.
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// NewTCPTransport creates a transport, that listens on the given tcp address and sends
+// each frame over a persistent connection to every address of the peer list. Lost
+// connections are reestablished with an exponential backoff. Frames are queued up to the
+// given size per peer. If a queue is full, further frames to that peer are dropped and
+// counted in the DroppedFrames of the fader's stats. A queue size of zero selects the
+// default of 1024. Errors are logged with the logger of the fader.
+func NewTCPTransport(address string, peers []string, queueSize int) (Transport, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("listen tcp: %w", err)
+	}
+
+	if queueSize <= 0 {
+		queueSize = defaultTCPQueueSize
+	}
+
+	t := &tcpTransport{
+		listener:  listener,
+		queueSize: queueSize,
+		incoming:  make(chan tcpFrame, tcpIncomingQueueSize),
+		peers:     make(map[string]*tcpPeer),
+		inbound:   make(map[net.Conn]struct{}),
+		done:      make(chan struct{}),
+	}
+	t.logger.Store(log.Default())
+	for _, peer := range peers {
+		if err := t.add(peer); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+
+	t.waitGroup.Add(1)
+	go t.acceptLoop()
+
+	return t, nil
+}
+
+type tcpFrame struct {
+	source net.Addr
+	data   []byte
+}
+
+type tcpTransport struct {
+	listener  net.Listener
+	queueSize int
+	incoming  chan tcpFrame
+	mutex     sync.Mutex
+	peers     map[string]*tcpPeer
+	inbound   map[net.Conn]struct{}
+	dropped   atomic.Uint64
+	logger    atomic.Pointer[log.Logger]
+	done      chan struct{}
+	closeOnce sync.Once
+	waitGroup sync.WaitGroup
+}
+
+func (t *tcpTransport) add(address string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	select {
+	case <-t.done:
+		return fmt.Errorf("add peer: %w", net.ErrClosed)
+	default:
+	}
+
+	if _, found := t.peers[address]; found {
+		return nil
+	}
+
+	peer := &tcpPeer{
+		address: address,
+		queue:   make(chan []byte, t.queueSize),
+		done:    make(chan struct{}),
+	}
+	t.peers[address] = peer
+
+	t.waitGroup.Add(1)
+	go func() {
+		defer t.waitGroup.Done()
+		peer.writeLoop()
+	}()
+	return nil
+}
+
+func (t *tcpTransport) remove(address string) error {
+	t.mutex.Lock()
+	peer, found := t.peers[address]
+	delete(t.peers, address)
+	t.mutex.Unlock()
+
+	if found {
+		peer.stop()
+	}
+	return nil
+}
+
+func (t *tcpTransport) list() []string {
+	t.mutex.Lock()
+	addresses := make([]string, 0, len(t.peers))
+	for address := range t.peers {
+		addresses = append(addresses, address)
+	}
+	t.mutex.Unlock()
+
+	sort.Strings(addresses)
+	return addresses
+}
+
+// Send places the frame in the write queue of every peer.
+func (t *tcpTransport) Send(frame []byte) error {
+	if len(frame) > maximalDatagramSize {
+		return fmt.Errorf("frame of size %d: %w", len(frame), ErrFrameTooLarge)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	select {
+	case <-t.done:
+		return fmt.Errorf("send frame: %w", net.ErrClosed)
+	default:
+	}
+
+	buffer := make([]byte, tcpFrameHeaderSize, tcpFrameHeaderSize+len(frame))
+	binary.BigEndian.PutUint32(buffer, uint32(len(frame)))
+	buffer = append(buffer, frame...)
+
+	for _, peer := range t.peers {
+		select {
+		case peer.queue <- buffer:
+		default:
+			t.dropped.Add(1)
+		}
+	}
+	return nil
+}
+
+func (t *tcpTransport) droppedFrames() uint64 {
+	return t.dropped.Load()
+}
+
+func (t *tcpTransport) setLogger(logger *log.Logger) {
+	t.logger.Store(logger)
+}
+
+func (t *tcpTransport) Receive(buffer []byte) (int, net.Addr, error) {
+	select {
+	case frame := <-t.incoming:
+		return copy(buffer, frame.data), frame.source, nil
+	case <-t.done:
+		return 0, nil, fmt.Errorf("receive frame: %w", net.ErrClosed)
+	}
+}
+
+// Close tears down all connections and waits until all goroutines have returned.
+func (t *tcpTransport) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		t.mutex.Lock()
+		close(t.done)
+		peers := t.peers
+		t.peers = make(map[string]*tcpPeer)
+		for connection := range t.inbound {
+			connection.Close()
+		}
+		t.mutex.Unlock()
+
+		if closeErr := t.listener.Close(); closeErr != nil {
+			err = fmt.Errorf("close listener: %w", closeErr)
+		}
+		for _, peer := range peers {
+			peer.stop()
+		}
+		t.waitGroup.Wait()
+	})
+	return err
+}
+
+func (t *tcpTransport) acceptLoop() {
+	defer t.waitGroup.Done()
+
+	for {
+		connection, err := t.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			t.logger.Load().Printf("accept tcp connection: %v", err)
+			continue
+		}
+
+		t.mutex.Lock()
+		select {
+		case <-t.done:
+			t.mutex.Unlock()
+			connection.Close()
+			return
+		default:
+		}
+		t.inbound[connection] = struct{}{}
+		t.waitGroup.Add(1)
+		t.mutex.Unlock()
+
+		go t.readLoop(connection)
+	}
+}
+
+// readLoop reads the frames from an inbound connection until it's closed.
+func (t *tcpTransport) readLoop(connection net.Conn) {
+	defer t.waitGroup.Done()
+	defer func() {
+		t.mutex.Lock()
+		delete(t.inbound, connection)
+		t.mutex.Unlock()
+		connection.Close()
+	}()
+
+	header := make([]byte, tcpFrameHeaderSize)
+	for {
+		if _, err := io.ReadFull(connection, header); err != nil {
+			return
+		}
+		size := binary.BigEndian.Uint32(header)
+		if size > maximalDatagramSize {
+			t.logger.Load().Printf("read tcp frame of size %d from [%s]: %v", size, connection.RemoteAddr(), ErrFrameTooLarge)
+			return
+		}
+
+		data := make([]byte, size)
+		if _, err := io.ReadFull(connection, data); err != nil {
+			return
+		}
+
+		select {
+		case t.incoming <- tcpFrame{source: connection.RemoteAddr(), data: data}:
+		case <-t.done:
+			return
+		}
+	}
+}
+
+type tcpPeer struct {
+	address    string
+	queue      chan []byte
+	pending    []byte
+	mutex      sync.Mutex
+	connection net.Conn
+	done       chan struct{}
+	stopOnce   sync.Once
+}
+
+// stop lets the write loop drain the queue and return. A pending write is cut short by
+// the drain timeout.
+func (p *tcpPeer) stop() {
+	p.stopOnce.Do(func() {
+		close(p.done)
+
+		p.mutex.Lock()
+		if p.connection != nil {
+			p.connection.SetWriteDeadline(time.Now().Add(tcpDrainTimeout))
+		}
+		p.mutex.Unlock()
+	})
+}
+
+// writeLoop connects to the peer and writes the queued frames. If the connection fails,
+// it's reestablished after a backoff, that doubles with each failed attempt. The frame,
+// that was written while the connection failed, is kept pending and written first on the
+// next connection. It returns once the peer has been stopped.
+func (p *tcpPeer) writeLoop() {
+	backoff := tcpMinimalBackoff
+	for {
+		connection, err := p.dial()
+		if err != nil {
+			select {
+			case <-time.After(backoff):
+			case <-p.done:
+				return
+			}
+			backoff = min(2*backoff, tcpMaximalBackoff)
+			continue
+		}
+		backoff = tcpMinimalBackoff
+
+		err = p.write(connection)
+		connection.Close()
+		if err == nil {
+			return
+		}
+	}
+}
+
+func (p *tcpPeer) dial() (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tcpDialTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-p.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	dialer := net.Dialer{}
+	connection, err := dialer.DialContext(ctx, "tcp", p.address)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	select {
+	case <-p.done:
+		connection.Close()
+		return nil, net.ErrClosed
+	default:
+	}
+	p.connection = connection
+	return connection, nil
+}
+
+// write writes the pending and the queued frames to the connection until the peer is
+// stopped, which results in a nil error, or writing fails. Once stopped, the frames that
+// are left in the queue are written within the drain timeout.
+func (p *tcpPeer) write(connection net.Conn) error {
+	for {
+		if p.pending == nil {
+			select {
+			case p.pending = <-p.queue:
+			case <-p.done:
+				return p.drain(connection)
+			}
+		}
+		if err := connection.SetWriteDeadline(time.Now().Add(tcpWriteTimeout)); err != nil {
+			return err
+		}
+		if _, err := connection.Write(p.pending); err != nil {
+			return err
+		}
+		p.pending = nil
+	}
+}
+
+func (p *tcpPeer) drain(connection net.Conn) error {
+	if err := connection.SetWriteDeadline(time.Now().Add(tcpDrainTimeout)); err != nil {
+		return nil
+	}
+	if p.pending != nil {
+		if _, err := connection.Write(p.pending); err != nil {
+			return nil
+		}
+		p.pending = nil
+	}
+	for {
+		select {
+		case frame := <-p.queue:
+			if _, err := connection.Write(frame); err != nil {
+				return nil
+			}
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package fader_test
+
+import (
+	"encoding/binary"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/posteo/fader"
+)
+
+func TestMulticastOverTCP(t *testing.T) {
+	transportOne, err := fader.NewTCPTransport("127.0.0.1:2201", []string{"127.0.0.1:2202"}, 0)
+	require.NoError(t, err)
+	faderOne, _ := setUpTransportFader(t, transportOne, multicastFaderIDOne)
+
+	// The item is queued until the peer is listening.
+	require.NoError(t, faderOne.Put([]byte("one"), time.Now(), []byte("value")))
+	time.Sleep(50 * time.Millisecond)
+
+	transportTwo, err := fader.NewTCPTransport("127.0.0.1:2202", []string{"127.0.0.1:2201"}, 0)
+	require.NoError(t, err)
+	faderTwo, _ := setUpTransportFader(t, transportTwo, multicastFaderIDTwo)
+
+	require.NoError(t, faderTwo.Put([]byte("two"), time.Now(), []byte("value")))
+	time.Sleep(300 * time.Millisecond)
+
+	assert.Equal(t, 2, faderOne.Size())
+	assert.Equal(t, 2, faderTwo.Size())
+	assert.Equal(t, []string{"127.0.0.1:2202"}, faderOne.PeerAddresses())
+}
+
+func TestTCPTransportDroppedFrames(t *testing.T) {
+	// Nothing listens on the peer's address, so the queue isn't drained.
+	transport, err := fader.NewTCPTransport("127.0.0.1:2203", []string{"127.0.0.1:2204"}, 1)
+	require.NoError(t, err)
+	multicastFader, _ := setUpTransportFader(t, transport, multicastFaderIDOne)
+
+	for range 5 {
+		require.NoError(t, multicastFader.Put([]byte("key"), time.Now(), []byte("value")))
+	}
+
+	assert.True(t, multicastFader.Stats().DroppedFrames >= 4)
+}
+
+// lineWriter passes every written line to the channel.
+type lineWriter chan string
+
+func (w lineWriter) Write(line []byte) (int, error) {
+	w <- string(line)
+	return len(line), nil
+}
+
+func TestTCPTransportLogger(t *testing.T) {
+	lines := make(lineWriter, 10)
+	transport, err := fader.NewTCPTransport("127.0.0.1:2205", nil, 0)
+	require.NoError(t, err)
+	setUpTransportFader(t, transport, multicastFaderIDOne, fader.WithLogger(log.New(lines, "", 0)))
+
+	connection, err := net.Dial("tcp", "127.0.0.1:2205")
+	require.NoError(t, err)
+	defer connection.Close()
+	_, err = connection.Write(binary.BigEndian.AppendUint32(nil, 1<<20))
+	require.NoError(t, err)
+
+	select {
+	case line := <-lines:
+		assert.True(t, strings.Contains(line, fader.ErrFrameTooLarge.Error()), line)
+	case <-time.After(time.Second):
+		t.Fatal("no log line")
+	}
+}
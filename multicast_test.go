@@ -15,6 +15,13 @@
 package fader_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
 	"testing"
 	"time"
 
@@ -25,15 +32,64 @@ import (
 )
 
 var (
-	multicastFaderIDOne = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
-	multicastFaderIDTwo = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
-	multicastKey        = []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	multicastFaderIDOne   = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	multicastFaderIDTwo   = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+	multicastFaderIDThree = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 2}
+	multicastKey          = []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
 )
 
 func setUpFader(tb testing.TB, id []byte) *fader.Multicast {
-	fader, err := fader.NewMulticast(fader.NewMemory(50*time.Millisecond), "224.0.0.1:2000", multicastKey, id, nil)
+	return setUpFaderWithExpiry(tb, id, 50*time.Millisecond)
+}
+
+func setUpFaderWithExpiry(tb testing.TB, id []byte, expiresIn time.Duration) *fader.Multicast {
+	memoryFader := fader.NewMemory(expiresIn)
+	multicastFader, err := fader.NewMulticast(memoryFader, "224.0.0.1:2000", multicastKey, id, nil)
+	require.NoError(tb, err)
+	tb.Cleanup(func() {
+		multicastFader.Close()
+		memoryFader.Close()
+	})
+	return multicastFader
+}
+
+// eventually polls the condition until it holds and fails the test, if it doesn't hold
+// within a second.
+func eventually(tb testing.TB, condition func() bool, message string) {
+	tb.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			tb.Fatal(message)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// setUpBusFader attaches a multicast fader with the given id and options to the bus. See
+// setUpTransportFader.
+func setUpBusFader(tb testing.TB, bus *fader.Bus, address string, id []byte, options ...fader.MulticastOption) (*fader.Multicast, *fader.Memory) {
+	transport, err := bus.Transport(address)
+	require.NoError(tb, err)
+	return setUpTransportFader(tb, transport, id, options...)
+}
+
+// setUpTransportFader returns a multicast fader with the given id and options, that
+// exchanges its packets via the transport. If the id is nil, a random one is used. The
+// parent is a memory fader, that expires items after a minute.
+func setUpTransportFader(tb testing.TB, transport fader.Transport, id []byte, options ...fader.MulticastOption) (*fader.Multicast, *fader.Memory) {
+	memoryFader := fader.NewMemory(time.Minute)
+	options = append([]fader.MulticastOption{fader.WithTransport(transport), fader.WithKey(multicastKey)}, options...)
+	if id != nil {
+		options = append(options, fader.WithID(id))
+	}
+	multicastFader, err := fader.NewMulticastWithOptions(memoryFader, options...)
 	require.NoError(tb, err)
-	return fader
+	tb.Cleanup(func() {
+		multicastFader.Close()
+		memoryFader.Close()
+	})
+	return multicastFader, memoryFader
 }
 
 func TestMulticastTransferBetweenTwoFaders(t *testing.T) {
@@ -98,27 +154,546 @@ func TestMulticastTransferOfStoreAndExpire(t *testing.T) {
 }
 
 func TestMulticastIfTransmissionFailsOnAReplyAttack(t *testing.T) {
+	bus := fader.NewBus()
+	faderOne, _ := setUpBusFader(t, bus, "one", multicastFaderIDOne)
+	faderTwo, _ := setUpBusFader(t, bus, "two", multicastFaderIDTwo)
+	spy, err := bus.Transport("spy")
+	require.NoError(t, err)
+	defer spy.Close()
+
+	require.NoError(t, faderOne.Put([]byte("test"), time.Now(), []byte("value")))
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Equal(t, 1, faderOne.Size())
+	assert.Equal(t, 1, faderTwo.Size())
+
+	// forge a reply attack
+	buffer := make([]byte, 1024)
+	n, source, err := spy.Receive(buffer)
+	for err == nil && source.String() != "one" {
+		n, source, err = spy.Receive(buffer)
+	}
+	require.NoError(t, err)
+	require.NoError(t, spy.Send(buffer[:n]))
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Equal(t, 1, faderTwo.Size())
+	assert.Equal(t, uint64(1), faderTwo.Stats().ReplayedPackets)
+}
+
+func TestMulticastTransferAfterRestartWithSameID(t *testing.T) {
+	bus := fader.NewBus()
+	faderTwo, _ := setUpBusFader(t, bus, "two", multicastFaderIDTwo)
+
+	faderOne, _ := setUpBusFader(t, bus, "one", multicastFaderIDOne)
+	require.NoError(t, faderOne.Put([]byte("before"), time.Now(), []byte("value")))
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, faderOne.Close())
+
+	restarted, _ := setUpBusFader(t, bus, "one", multicastFaderIDOne)
+	require.NoError(t, restarted.Put([]byte("after"), time.Now(), []byte("value")))
+	time.Sleep(10 * time.Millisecond)
+
+	_, value := faderTwo.Get([]byte("after"))
+	assert.Equal(t, []byte("value"), value)
+	assert.Equal(t, uint64(0), faderTwo.Stats().ReplayedPackets)
+}
+
+func TestMulticastTransferDuringKeyRotation(t *testing.T) {
+	newKey := []byte{15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0}
+
 	faderOne := setUpFader(t, multicastFaderIDOne)
 	faderTwo := setUpFader(t, multicastFaderIDTwo)
 
+	require.NoError(t, faderOne.Keyring().Add(1, newKey))
+	require.NoError(t, faderTwo.Keyring().Add(1, newKey))
+	require.NoError(t, faderOne.Keyring().SetCurrent(1))
+
 	now := time.Now()
-	require.NoError(t, faderOne.Put([]byte("test"), now, []byte("value")))
+	require.NoError(t, faderOne.Put([]byte("one"), now, []byte("value one")))
+	require.NoError(t, faderTwo.Put([]byte("two"), now, []byte("value two")))
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Equal(t, 2, faderOne.Size())
+	assert.Equal(t, 2, faderTwo.Size())
+
+	stats := faderTwo.Keyring().Stats()
+	require.Len(t, stats, 2)
+	assert.Equal(t, uint64(1), stats[1].Decrypted)
+}
+
+func TestMulticastPutOfTooLargeItems(t *testing.T) {
+	multicastFader := setUpFader(t, multicastFaderIDOne)
+
+	err := multicastFader.Put(make([]byte, 70000), time.Now(), []byte("value"))
+	assert.True(t, errors.Is(err, fader.ErrKeyTooLarge))
+
+	err = multicastFader.Put([]byte("key"), time.Now(), make([]byte, 2<<20))
+	assert.True(t, errors.Is(err, fader.ErrItemTooLarge))
+
+	assert.Equal(t, 0, multicastFader.Size())
+}
+
+func TestMulticastTransferOfLargeItem(t *testing.T) {
+	faderOne := setUpFader(t, multicastFaderIDOne)
+	faderTwo := setUpFader(t, multicastFaderIDTwo)
+
+	value := bytes.Repeat([]byte("0123456789"), 1000)
+	require.NoError(t, faderOne.Put([]byte("large"), time.Now(), value))
+	time.Sleep(10 * time.Millisecond)
+
+	_, receivedValue := faderTwo.Get([]byte("large"))
+	assert.Equal(t, value, receivedValue)
+	assert.True(t, faderOne.Stats().FragmentsSent > 1)
+	assert.Equal(t, uint64(1), faderTwo.Stats().Reassembled)
+
+	faderOne.SetMaximalItemSize(1000)
+	err := faderOne.Put([]byte("large"), time.Now(), value)
+	assert.True(t, errors.Is(err, fader.ErrItemTooLarge))
+}
+
+func TestMulticastTransferOfItemLargerThanAPacket(t *testing.T) {
+	bus := fader.NewBus()
+	faderOne, _ := setUpBusFader(t, bus, "one", multicastFaderIDOne)
+	faderTwo, _ := setUpBusFader(t, bus, "two", multicastFaderIDTwo)
+
+	value := bytes.Repeat([]byte("0123456789"), 10000)
+	require.NoError(t, faderOne.Put([]byte("large"), time.Now(), value))
+	time.Sleep(50 * time.Millisecond)
+
+	_, receivedValue := faderTwo.Get([]byte("large"))
+	assert.Equal(t, value, receivedValue)
+	assert.Equal(t, uint64(1), faderTwo.Stats().Reassembled)
+}
+
+func TestMulticastAggregationOfPackets(t *testing.T) {
+	faderOne := setUpFader(t, multicastFaderIDOne)
+	faderTwo := setUpFader(t, multicastFaderIDTwo)
+	faderOne.SetFlushInterval(20 * time.Millisecond)
+
+	now := time.Now()
+	require.NoError(t, faderOne.Put([]byte("one"), now, []byte("value one")))
+	require.NoError(t, faderOne.Put([]byte("two"), now, []byte("value two")))
+	require.NoError(t, faderOne.Put([]byte("three"), now, []byte("value three")))
+	time.Sleep(5 * time.Millisecond)
+
+	assert.Equal(t, 3, faderOne.Size())
+	assert.Equal(t, 0, faderTwo.Size())
+	time.Sleep(25 * time.Millisecond)
+
+	assert.Equal(t, 3, faderTwo.Size())
+	assert.Equal(t, uint64(3), faderOne.Stats().PacketsSent)
+	assert.Equal(t, uint64(1), faderOne.Stats().DatagramsSent)
+}
+
+func TestMulticastSyncOfJoiningFader(t *testing.T) {
+	faderOne := setUpFaderWithExpiry(t, multicastFaderIDOne, time.Second)
+
+	now := time.Now()
+	require.NoError(t, faderOne.Put([]byte("one"), now, []byte("value one")))
+	require.NoError(t, faderOne.Put([]byte("two"), now, []byte("value two")))
+	require.NoError(t, faderOne.Put([]byte("three"), now, []byte("value three")))
+
+	faderTwo := setUpFaderWithExpiry(t, multicastFaderIDTwo, time.Second)
+	assert.Equal(t, 0, faderTwo.Size())
+
+	require.NoError(t, faderTwo.RequestSync())
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(t, 3, faderTwo.Size())
+	_, value := faderTwo.Get([]byte("two"))
+	assert.Equal(t, "value two", string(value))
+	assert.Equal(t, uint64(3), faderOne.Stats().SyncItemsSent)
+
+	// a second request within the minimal interval is ignored
+	require.NoError(t, faderTwo.RequestSync())
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(t, 3, faderTwo.Size())
+	assert.Equal(t, uint64(1), faderOne.Stats().SyncRequestsIgnored)
+}
+
+func TestMulticastSyncOnJoin(t *testing.T) {
+	bus := fader.NewBus()
+	faderOne, _ := setUpBusFader(t, bus, "one", multicastFaderIDOne)
+	require.NoError(t, faderOne.Put([]byte("one"), time.Now(), []byte("value one")))
+	require.NoError(t, faderOne.Put([]byte("two"), time.Now(), []byte("value two")))
+
+	faderTwo, _ := setUpBusFader(t, bus, "two", multicastFaderIDTwo, fader.WithSyncOnJoin())
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(t, 2, faderTwo.Size())
+	assert.Equal(t, uint64(1), faderTwo.Stats().SyncRequestsSent)
+}
+
+func TestMulticastAntiEntropyRepairsLostItems(t *testing.T) {
+	memoryOne := fader.NewMemory(10 * time.Second)
+	faderOne, err := fader.NewMulticast(memoryOne, "224.0.0.1:2000", multicastKey, multicastFaderIDOne, nil)
+	require.NoError(t, err)
+	defer faderOne.Close()
+	defer memoryOne.Close()
+
+	memoryTwo := fader.NewMemory(10 * time.Second)
+	faderTwo, err := fader.NewMulticast(memoryTwo, "224.0.0.1:2000", multicastKey, multicastFaderIDTwo, nil)
+	require.NoError(t, err)
+	defer faderTwo.Close()
+	defer memoryTwo.Close()
+
+	// items that are put into the parents directly, simulate lost packets
+	past := time.Now().Add(-3 * time.Second)
+	require.NoError(t, memoryOne.Put([]byte("one"), past, []byte("value one")))
+	require.NoError(t, memoryOne.Put([]byte("both"), past, []byte("value both")))
+	require.NoError(t, memoryTwo.Put([]byte("both"), past, []byte("value both")))
+	require.NoError(t, memoryTwo.Put([]byte("two"), past.Add(-time.Second), []byte("value two")))
+
+	faderOne.SetAntiEntropyInterval(20 * time.Millisecond)
+	faderTwo.SetAntiEntropyInterval(20 * time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+
+	assert.Equal(t, 3, faderOne.Size())
+	assert.Equal(t, 3, faderTwo.Size())
+	_, value := faderOne.Get([]byte("two"))
+	assert.Equal(t, "value two", string(value))
+	_, value = faderTwo.Get([]byte("one"))
+	assert.Equal(t, "value one", string(value))
+
+	assert.True(t, faderOne.Stats().DigestsSent > 0)
+	assert.True(t, faderTwo.Stats().RangeRequestsSent > 0)
+}
+
+func TestMulticastClearAll(t *testing.T) {
+	faderOne := setUpFaderWithExpiry(t, multicastFaderIDOne, time.Second)
+	faderTwo := setUpFaderWithExpiry(t, multicastFaderIDTwo, time.Second)
+
+	past := time.Now()
+	require.NoError(t, faderOne.Put([]byte("one"), past, []byte("value one")))
+	require.NoError(t, faderTwo.Put([]byte("two"), past, []byte("value two")))
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Equal(t, 2, faderOne.Size())
+	assert.Equal(t, 2, faderTwo.Size())
+
+	require.NoError(t, faderOne.ClearAll())
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Equal(t, 0, faderOne.Size())
+	assert.Equal(t, 0, faderTwo.Size())
+	assert.Equal(t, faderOne.ClearedAt(), faderTwo.ClearedAt())
+
+	// a put from before the clear is rejected
+	err := faderOne.Put([]byte("late"), past, []byte("late value"))
+	assert.True(t, errors.Is(err, fader.ErrStaleItem))
+	require.NoError(t, faderOne.Put([]byte("new"), time.Now(), []byte("new value")))
 	time.Sleep(10 * time.Millisecond)
 
 	assert.Equal(t, 1, faderOne.Size())
 	assert.Equal(t, 1, faderTwo.Size())
+	_, value := faderTwo.Get([]byte("new"))
+	assert.Equal(t, "new value", string(value))
 
-	// forge a reply attack
-	memoryFader := fader.NewMemory(50 * time.Millisecond)
-	defer memoryFader.Close()
+	// a late put of a node, that missed the clear, is not resurrected
+	faderThree := setUpFaderWithExpiry(t, multicastFaderIDThree, time.Second)
+	require.NoError(t, faderThree.Put([]byte("late"), past, []byte("late value")))
+	time.Sleep(10 * time.Millisecond)
 
-	multicastFader, err := fader.NewMulticast(memoryFader, "224.0.0.1:2000", multicastKey, multicastFaderIDOne, nil)
+	assert.Equal(t, 1, faderOne.Size())
+	assert.Equal(t, 1, faderTwo.Size())
+	assert.Equal(t, uint64(1), faderTwo.Stats().StalePuts)
+
+	faderTwo.ClearLocal()
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Equal(t, 1, faderOne.Size())
+	assert.Equal(t, 0, faderTwo.Size())
+}
+
+func TestMulticastConcurrentPutAndClearAll(t *testing.T) {
+	bus := fader.NewBus()
+	faderOne, memoryOne := setUpBusFader(t, bus, "one", multicastFaderIDOne)
+	faderTwo, memoryTwo := setUpBusFader(t, bus, "two", multicastFaderIDTwo)
+
+	for round := range 100 {
+		putTimes := map[string]time.Time{}
+		cleared, putsDone := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(putsDone)
+			for index := 0; ; index++ {
+				select {
+				case <-cleared:
+					return
+				default:
+				}
+				key, now := fmt.Sprintf("key %d %d", round, index), time.Now()
+				if err := faderOne.Put([]byte(key), now, []byte("value")); err == nil {
+					putTimes[key] = now
+				} else {
+					assert.True(t, errors.Is(err, fader.ErrStaleItem))
+				}
+			}
+		}()
+		require.NoError(t, faderTwo.ClearAll())
+		close(cleared)
+		<-putsDone
+
+		// Every item, that is newer than the clear, survives it on both nodes.
+		eventually(t, func() bool {
+			clearedAt := faderTwo.ClearedAt()
+			if !faderOne.ClearedAt().Equal(clearedAt) {
+				return false
+			}
+			for key, putTime := range putTimes {
+				if !putTime.After(clearedAt) {
+					continue
+				}
+				timeOne, _ := memoryOne.Get([]byte(key))
+				timeTwo, _ := memoryTwo.Get([]byte(key))
+				if !timeOne.Equal(putTime) || !timeTwo.Equal(putTime) {
+					return false
+				}
+			}
+			return true
+		}, "items, that are newer than the clear, are missing")
+	}
+}
+
+func TestMulticastPeerMembership(t *testing.T) {
+	events := make(chan fader.PeerEvent, 10)
+	bus := fader.NewBus()
+	faderOne, _ := setUpBusFader(t, bus, "one", multicastFaderIDOne,
+		fader.WithHeartbeatInterval(10*time.Millisecond),
+		fader.WithPeerHandler(func(event fader.PeerEvent, peer fader.Peer) {
+			if bytes.Equal(peer.ID, multicastFaderIDTwo) {
+				events <- event
+			}
+		}))
+	faderTwo, memoryTwo := setUpBusFader(t, bus, "two", multicastFaderIDTwo,
+		fader.WithHeartbeatInterval(10*time.Millisecond),
+		fader.WithLabel("two"))
+	require.NoError(t, memoryTwo.Put([]byte("key"), time.Now(), []byte("value")))
+
+	eventually(t, func() bool {
+		peers := faderOne.Peers()
+		return len(peers) == 1 && peers[0].Items == 1
+	}, "peer two has not been announced")
+	peers := faderOne.Peers()
+	assert.Equal(t, multicastFaderIDTwo, peers[0].ID)
+	assert.Equal(t, "two", peers[0].Label)
+	assert.Equal(t, 1, peers[0].ProtocolVersion)
+	assert.Equal(t, fader.PeerAlive, peers[0].State)
+	assert.Equal(t, fader.PeerJoined, <-events)
+
+	require.NoError(t, faderTwo.Close())
+	eventually(t, func() bool {
+		peers := faderOne.Peers()
+		return len(peers) == 1 && peers[0].State == fader.PeerDead
+	}, "peer two has not left")
+	assert.Equal(t, fader.PeerLeft, <-events)
+
+	// dead peers are removed after a grace period
+	eventually(t, func() bool { return len(faderOne.Peers()) == 0 }, "peer two has not been removed")
+}
+
+func TestUnicastTransferBetweenTwoFaders(t *testing.T) {
+	memoryOne, memoryTwo := fader.NewMemory(time.Second), fader.NewMemory(time.Second)
+	defer memoryOne.Close()
+	defer memoryTwo.Close()
+
+	faderOne, err := fader.NewUnicast(memoryOne, "127.0.0.1:2101", []string{"127.0.0.1:2102"}, multicastKey, multicastFaderIDOne, nil)
+	require.NoError(t, err)
+	defer faderOne.Close()
+	faderTwo, err := fader.NewUnicast(memoryTwo, "127.0.0.1:2102", nil, multicastKey, multicastFaderIDTwo, nil)
 	require.NoError(t, err)
-	defer multicastFader.Close()
+	defer faderTwo.Close()
 
-	require.NoError(t, multicastFader.Put([]byte("test"), now, []byte("value")))
+	require.NoError(t, faderOne.Put([]byte("one"), time.Now(), []byte("value")))
+	require.NoError(t, faderTwo.Put([]byte("two"), time.Now(), []byte("value")))
 	time.Sleep(10 * time.Millisecond)
 
 	assert.Equal(t, 1, faderOne.Size())
+	assert.Equal(t, 2, faderTwo.Size())
+
+	require.NoError(t, faderTwo.AddPeer("127.0.0.1:2101"))
+	assert.Equal(t, []string{"127.0.0.1:2101"}, faderTwo.PeerAddresses())
+	require.NoError(t, faderTwo.Put([]byte("three"), time.Now(), []byte("value")))
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Equal(t, 2, faderOne.Size())
+
+	require.NoError(t, faderOne.RemovePeer("127.0.0.1:2102"))
+	assert.Empty(t, faderOne.PeerAddresses())
+	require.NoError(t, faderOne.Put([]byte("four"), time.Now(), []byte("value")))
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Equal(t, 3, faderTwo.Size())
+}
+
+func TestMulticastWithoutPeerList(t *testing.T) {
+	multicastFader := setUpFader(t, multicastFaderIDOne)
+
+	assert.True(t, errors.Is(multicastFader.AddPeer("127.0.0.1:2101"), fader.ErrNoPeerList))
+	assert.Nil(t, multicastFader.PeerAddresses())
+}
+
+func TestMulticastWithOptions(t *testing.T) {
+	memoryFader := fader.NewMemory(time.Second)
+	defer memoryFader.Close()
+
+	t.Run("Valid", func(t *testing.T) {
+		transport, err := fader.NewBus().Transport("one")
+		require.NoError(t, err)
+
+		multicastFader, err := fader.NewMulticastWithOptions(memoryFader,
+			fader.WithTransport(transport),
+			fader.WithKey(multicastKey),
+			fader.WithID(multicastFaderIDOne),
+			fader.WithLabel("one"),
+			fader.WithMaximalItemSize(16),
+			fader.WithLogger(log.New(io.Discard, "", 0)),
+		)
+		require.NoError(t, err)
+		defer multicastFader.Close()
+
+		err = multicastFader.Put([]byte("key"), time.Now(), make([]byte, 16))
+		assert.True(t, errors.Is(err, fader.ErrItemTooLarge))
+	})
+
+	transport, err := fader.NewBus().Transport("two")
+	require.NoError(t, err)
+	defer transport.Close()
+
+	for name, options := range map[string][]fader.MulticastOption{
+		"MissingKey":      {fader.WithAddress("224.0.0.1:2000")},
+		"MissingAddress":  {fader.WithKey(multicastKey)},
+		"InvalidID":       {fader.WithAddress("224.0.0.1:2000"), fader.WithKey(multicastKey), fader.WithID([]byte{1})},
+		"InvalidTTL":      {fader.WithAddress("224.0.0.1:2000"), fader.WithKey(multicastKey), fader.WithTTL(0)},
+		"InvalidInterval": {fader.WithAddress("224.0.0.1:2000"), fader.WithKey(multicastKey), fader.WithHeartbeatInterval(0)},
+		"InvalidTimeout":  {fader.WithAddress("224.0.0.1:2000"), fader.WithKey(multicastKey), fader.WithReassemblyTimeout(0)},
+		"InvalidMemory":   {fader.WithAddress("224.0.0.1:2000"), fader.WithKey(multicastKey), fader.WithReassemblyMemory(0)},
+		"TransportAndTTL": {fader.WithTransport(transport), fader.WithKey(multicastKey), fader.WithTTL(2)},
+		"BothHandlers": {
+			fader.WithTransport(transport), fader.WithKey(multicastKey),
+			fader.WithReceivedHandler(func([]byte, time.Time, []byte) bool { return true }),
+			fader.WithItemHandler(func(*fader.ReceivedItem) bool { return true }),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := fader.NewMulticastWithOptions(memoryFader, options...)
+			assert.True(t, errors.Is(err, fader.ErrInvalidOption), "%v", err)
+		})
+	}
+
+	t.Run("InvalidKeyLength", func(t *testing.T) {
+		_, err := fader.NewMulticastWithOptions(memoryFader, fader.WithAddress("224.0.0.1:2000"), fader.WithKey([]byte{1}))
+		assert.True(t, errors.Is(err, fader.ErrInvalidKeyLength))
+	})
+}
+
+func TestMulticastConcurrentPut(t *testing.T) {
+	const goroutines, puts = 16, 50
+
+	for name, flushInterval := range map[string]time.Duration{"Immediate": 0, "Aggregated": time.Millisecond} {
+		t.Run(name, func(t *testing.T) {
+			bus := fader.NewBus()
+			faderOne, _ := setUpBusFader(t, bus, "one", multicastFaderIDOne, fader.WithFlushInterval(flushInterval))
+			faderTwo, _ := setUpBusFader(t, bus, "two", multicastFaderIDTwo, fader.WithFlushInterval(flushInterval))
+
+			group := sync.WaitGroup{}
+			for goroutine := range goroutines {
+				group.Add(1)
+				go func() {
+					defer group.Done()
+					for put := range puts {
+						key := []byte(fmt.Sprintf("key %d %d", goroutine, put))
+						assert.NoError(t, faderOne.Put(key, time.Now(), []byte("value")))
+					}
+				}()
+			}
+			group.Wait()
+			require.NoError(t, faderOne.Flush())
+
+			deadline := time.Now().Add(time.Second)
+			for faderTwo.Size() < goroutines*puts && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			assert.Equal(t, goroutines*puts, faderTwo.Size())
+			assert.Equal(t, uint64(goroutines*puts), faderTwo.Stats().PacketsReceived)
+		})
+	}
+}
+
+func TestMulticastShutdown(t *testing.T) {
+	bus := fader.NewBus()
+	faderOne, memoryOne := setUpBusFader(t, bus, "one", nil,
+		fader.WithFlushInterval(time.Hour),
+		fader.WithOwnedParent())
+	faderTwo, _ := setUpBusFader(t, bus, "two", nil)
+
+	require.NoError(t, faderOne.Put([]byte("key"), time.Now(), []byte("value")))
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, faderTwo.Size())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, faderOne.Shutdown(ctx))
+	require.NoError(t, faderOne.Shutdown(ctx))
+	time.Sleep(10 * time.Millisecond)
+
+	// The pending item has been flushed.
 	assert.Equal(t, 1, faderTwo.Size())
+
+	err := faderOne.Put([]byte("key"), time.Now(), []byte("value"))
+	assert.True(t, errors.Is(err, fader.ErrClosed))
+
+	// The owned parent has been closed as well.
+	err = memoryOne.Put([]byte("key"), time.Now(), []byte("value"))
+	assert.True(t, errors.Is(err, fader.ErrClosed))
+}
+
+func TestMulticastShutdownSendsLeavingHeartbeatLast(t *testing.T) {
+	bus := fader.NewBus()
+	faderOne, _ := setUpBusFader(t, bus, "one", multicastFaderIDOne)
+	faderTwo, _ := setUpBusFader(t, bus, "two", multicastFaderIDTwo,
+		fader.WithHeartbeatInterval(time.Millisecond))
+
+	eventually(t, func() bool { return len(faderOne.Peers()) == 1 }, "peer two has not been announced")
+	require.NoError(t, faderTwo.Close())
+	eventually(t, func() bool { return faderOne.Peers()[0].State == fader.PeerDead }, "peer two has not left")
+
+	// No heartbeat follows the leaving one, that would revive the peer.
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, fader.PeerDead, faderOne.Peers()[0].State)
+}
+
+func TestMulticastItemHandler(t *testing.T) {
+	bus := fader.NewBus()
+	items := make(chan fader.ReceivedItem, 10)
+	_, parent := setUpBusFader(t, bus, "receiver", multicastFaderIDOne,
+		fader.WithItemHandler(func(item *fader.ReceivedItem) bool {
+			items <- *item
+			if string(item.Key) == "drop" {
+				return false
+			}
+			item.Key = append([]byte("peer:"), item.Key...)
+			return true
+		}))
+	sender, _ := setUpBusFader(t, bus, "sender", multicastFaderIDTwo)
+
+	now := time.Now()
+	require.NoError(t, sender.Put([]byte("key"), now, []byte("value")))
+	require.NoError(t, sender.Put([]byte("drop"), now, []byte("value")))
+
+	first, second := <-items, <-items
+	assert.Equal(t, []byte("key"), first.Key)
+	assert.Equal(t, multicastFaderIDTwo, first.Sender)
+	assert.Equal(t, fader.BusAddr("sender"), first.Source)
+	assert.Equal(t, 1, second.Nonce.Cmp(first.Nonce))
+	assert.False(t, first.ReceivedAt.IsZero())
+	assert.True(t, first.Size > len("key")+len("value"))
+
+	time.Sleep(10 * time.Millisecond)
+	_, value := parent.Get([]byte("peer:key"))
+	assert.Equal(t, []byte("value"), value)
+	_, value = parent.Get([]byte("key"))
+	assert.Nil(t, value)
+	assert.Equal(t, 1, parent.Size())
 }
@@ -20,6 +20,8 @@ import (
 	"fmt"
 	"log"
 	"math/big"
+	"net"
+	"time"
 
 	"github.com/posteo/fader/crypt"
 )
@@ -27,28 +29,47 @@ import (
 const (
 	idSize                 = 10
 	maximalWriteBufferSize = 512
+	maximalDatagramSize    = 65535
 )
 
+// multicastTransmitter encrypts the outgoing and decrypts the incoming packets. The write
+// side, Write, Buffered and Flush, shares the write buffer and the nonce, so it must not
+// be used concurrently. Multicast serializes it with its send mutex, which also makes
+// sure that a nonce is never used twice. The read side is only used by the receive loop.
 type multicastTransmitter struct {
 	writer        crypt.Writer
 	reader        crypt.Reader
+	transport     Transport
+	frame         *bytes.Reader
+	datagram      []byte
 	writeBuffer   *bytes.Buffer
 	id            []byte
 	nonce         *big.Int
 	foreignNonces map[string]*big.Int
+	signer        *signer
+	logger        *log.Logger
+	admit         func() bool
 }
 
-func newMulticastTransmitter(writer crypt.Writer, reader crypt.Reader, id []byte) *multicastTransmitter {
+// The transport is expected to return exactly one encrypted frame on each receive. If the
+// signer is nil, the frames are not signed.
+func newMulticastTransmitter(transport Transport, keyring *crypt.Keyring, id []byte, signer *signer, logger *log.Logger) *multicastTransmitter {
 	if id == nil || len(id) != 10 {
 		id = randomBytes(idSize)
 	}
+	frame := &bytes.Reader{}
 	return &multicastTransmitter{
-		writer:        writer,
-		reader:        reader,
+		writer:        crypt.NewKeyringEncrypter(transportWriter{transport}, keyring),
+		reader:        crypt.NewKeyringDecrypter(frame, keyring),
+		transport:     transport,
+		frame:         frame,
+		datagram:      make([]byte, maximalDatagramSize),
 		writeBuffer:   &bytes.Buffer{},
 		id:            id,
-		nonce:         big.NewInt(0),
+		nonce:         bootNonce(time.Now()),
 		foreignNonces: make(map[string]*big.Int),
+		signer:        signer,
+		logger:        logger,
 	}
 }
 
@@ -56,13 +77,24 @@ func (t *multicastTransmitter) Write(payload []byte) (int, error) {
 	return t.writeBuffer.Write(payload)
 }
 
+// Buffered returns the number of bytes in the write buffer.
+func (t *multicastTransmitter) Buffered() int {
+	return t.writeBuffer.Len()
+}
+
 func (t *multicastTransmitter) Flush() error {
 	if t.writeBuffer.Len() > maximalWriteBufferSize {
-		log.Printf("send an udp multicast packet of size %d, should not exceed %d",
+		t.logger.Printf("send an udp multicast packet of size %d, should not exceed %d",
 			t.writeBuffer.Len(), maximalWriteBufferSize)
 	}
 
-	buffer := append(t.id, t.writeBuffer.Bytes()...)
+	var buffer []byte
+	if t.signer != nil {
+		buffer = t.signer.sign(t.id, t.nonce, t.writeBuffer.Bytes())
+	} else {
+		buffer = make([]byte, 0, len(t.id)+t.writeBuffer.Len())
+		buffer = append(append(buffer, t.id...), t.writeBuffer.Bytes()...)
+	}
 	if _, err := t.writer.Write(t.nonce, buffer); err != nil {
 		t.increaseNonce()
 		return fmt.Errorf("write: %w", err)
@@ -73,31 +105,77 @@ func (t *multicastTransmitter) Flush() error {
 	return nil
 }
 
-func (t *multicastTransmitter) Read(payload []byte) (int, error) {
+// receivedFrame describes the frame, that a received payload has been carried by.
+type receivedFrame struct {
+	sender     []byte
+	source     net.Addr
+	nonce      *big.Int
+	receivedAt time.Time
+}
+
+// Read places the payload of the next packet in the given slice and returns the frame it
+// has been received with. Packets of this node are skipped, as well as frames, that are
+// not admitted before their decryption, if an admit function is set. All errors are
+// returned as a *ReceiveError.
+func (t *multicastTransmitter) Read(payload []byte) (*receivedFrame, int, error) {
 	buffer := make([]byte, idSize+len(payload))
 	packet := []byte{}
 
 	nonce := big.NewInt(0)
+	var source net.Addr
 	for {
-		n, err := t.reader.Read(nonce, buffer)
+		n, address, err := t.transport.Receive(t.datagram)
 		if err != nil {
-			return 0, fmt.Errorf("read: %w", err)
+			return nil, 0, &ReceiveError{Kind: ReceiveErrorTransport, Err: fmt.Errorf("receive frame: %w", err)}
 		}
-		packet = buffer[:n]
-
-		id := packet[:idSize]
-		if bytes.Equal(t.id, id) {
+		if t.admit != nil && !t.admit() {
 			continue
 		}
+		source = address
+		t.frame.Reset(t.datagram[:n])
 
-		if !t.validNonce(id, nonce) {
-			continue
+		n, err = t.reader.Read(nonce, buffer)
+		if err != nil {
+			return nil, 0, &ReceiveError{Kind: ReceiveErrorDecrypt, Err: fmt.Errorf("read: %w", err)}
+		}
+		packet = buffer[:n]
+		if len(packet) < idSize {
+			err := fmt.Errorf("packet of size %d: %w", len(packet), ErrTruncatedPacket)
+			return nil, 0, &ReceiveError{Kind: ReceiveErrorDecode, Err: err}
 		}
 
-		break
+		if !bytes.Equal(t.id, packet[:idSize]) {
+			break
+		}
 	}
 
-	return copy(payload, packet[idSize:]), nil
+	id := append([]byte{}, packet[:idSize]...)
+	content := packet[idSize:]
+	if t.signer != nil {
+		var err error
+		if content, err = t.signer.verify(id, nonce, packet); err != nil {
+			return nil, 0, &ReceiveError{Kind: ReceiveErrorSignature, Sender: id, Err: err}
+		}
+	}
+	if !t.validNonce(id, nonce) {
+		err := fmt.Errorf("nonce %s: %w", nonce, ErrReplayedPacket)
+		return nil, 0, &ReceiveError{Kind: ReceiveErrorReplay, Sender: id, Err: err}
+	}
+	frame := &receivedFrame{
+		sender: id,
+		source: source,
+		nonce:  new(big.Int).Set(nonce),
+	}
+	return frame, copy(payload, content), nil
+}
+
+// bootNonce returns the first nonce of a transmitter. The nonces are counted up from the
+// boot time, so that a node, that restarts with the same id, continues above the nonces
+// it used before. Otherwise its peers would drop its frames as replayed, and the frames
+// would be encrypted with nonces that have been used before. This relies on a clock that
+// doesn't go back between the restarts.
+func bootNonce(now time.Time) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(now.UnixNano()), 32)
 }
 
 func (t *multicastTransmitter) increaseNonce() {
@@ -0,0 +1,181 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+)
+
+// ErrNoPeerList is returned if peers are added to or removed from a fader, whose transport
+// doesn't send its frames to a list of peers.
+var ErrNoPeerList = errors.New("no peer list")
+
+// Transport defines the interface of the layer, that carries the encrypted frames between
+// the nodes of a group.
+//
+// Send delivers the frame to all other nodes. A transport may drop frames, but must never
+// split or merge them. Receive places the next frame of another node in the buffer and
+// returns its size and the address it was received from. After Close, Receive and Send
+// must return an error that wraps net.ErrClosed.
+type Transport interface {
+	Send(frame []byte) error
+	Receive(buffer []byte) (int, net.Addr, error)
+	Close() error
+}
+
+// peerList is implemented by transports, that send their frames to a list of peers.
+type peerList interface {
+	add(address string) error
+	remove(address string) error
+	list() []string
+}
+
+// droppingTransport is implemented by transports, that count the frames they drop.
+type droppingTransport interface {
+	droppedFrames() uint64
+}
+
+// loggingTransport is implemented by transports, that log errors, which can't be returned
+// from Send or Receive. The fader passes its logger on.
+type loggingTransport interface {
+	setLogger(logger *log.Logger)
+}
+
+// AddPeer adds the address to the peer list of the transport.
+func (m *Multicast) AddPeer(address string) error {
+	transport, ok := m.transport.(peerList)
+	if !ok {
+		return ErrNoPeerList
+	}
+	return transport.add(address)
+}
+
+// RemovePeer removes the address from the peer list of the transport.
+func (m *Multicast) RemovePeer(address string) error {
+	transport, ok := m.transport.(peerList)
+	if !ok {
+		return ErrNoPeerList
+	}
+	return transport.remove(address)
+}
+
+// PeerAddresses returns the peer list of the transport. For a transport without a peer
+// list, like udp multicast, nil is returned.
+func (m *Multicast) PeerAddresses() []string {
+	transport, ok := m.transport.(peerList)
+	if !ok {
+		return nil
+	}
+	return transport.list()
+}
+
+// NewMulticastTransport creates a transport, that joins the udp multicast group of the
+// given address with the default socket options.
+func NewMulticastTransport(address string) (Transport, error) {
+	return NewMulticastTransportWithConfig(address, MulticastTransportConfig{})
+}
+
+// NewMulticastTransportWithConfig creates a transport, that joins the udp multicast group
+// of the given address. Both IPv4 and IPv6 groups are supported, e.g. "224.0.0.1:2000" or
+// "[ff05::1:2]:2000".
+func NewMulticastTransportWithConfig(address string, config MulticastTransportConfig) (Transport, error) {
+	udpAddress, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("resolve udp address [%s]: %w", address, err)
+	}
+	if !udpAddress.IP.IsMulticast() {
+		return nil, fmt.Errorf("address [%s]: %w", address, ErrNoMulticastAddress)
+	}
+
+	network := "udp4"
+	if udpAddress.IP.To4() == nil {
+		network = "udp6"
+	}
+
+	iface, err := config.networkInterface()
+	if err != nil {
+		return nil, err
+	}
+	if network == "udp6" && udpAddress.Zone == "" && iface != nil {
+		udpAddress.Zone = iface.Name
+	}
+
+	incomingConnection, err := net.ListenMulticastUDP(network, iface, udpAddress)
+	if err != nil {
+		return nil, fmt.Errorf("listen Multicast udp: %w", err)
+	}
+	if config.ReceiveBufferSize > 0 {
+		if err := incomingConnection.SetReadBuffer(config.ReceiveBufferSize); err != nil {
+			incomingConnection.Close()
+			return nil, fmt.Errorf("set receive buffer size: %w", err)
+		}
+	}
+
+	outgoingConnection, err := net.DialUDP(network, nil, udpAddress)
+	if err != nil {
+		incomingConnection.Close()
+		return nil, fmt.Errorf("dial udp: %w", err)
+	}
+	if err := config.apply(outgoingConnection, network, iface); err != nil {
+		incomingConnection.Close()
+		outgoingConnection.Close()
+		return nil, err
+	}
+
+	return &multicastTransport{
+		incomingConnection: incomingConnection,
+		outgoingConnection: outgoingConnection,
+	}, nil
+}
+
+type multicastTransport struct {
+	incomingConnection *net.UDPConn
+	outgoingConnection *net.UDPConn
+}
+
+func (t *multicastTransport) Send(frame []byte) error {
+	_, err := t.outgoingConnection.Write(frame)
+	return err
+}
+
+func (t *multicastTransport) Receive(buffer []byte) (int, net.Addr, error) {
+	return t.incomingConnection.ReadFromUDP(buffer)
+}
+
+func (t *multicastTransport) Close() error {
+	if err := t.incomingConnection.Close(); err != nil {
+		return fmt.Errorf("close incoming connection: %w", err)
+	}
+	if err := t.outgoingConnection.Close(); err != nil {
+		return fmt.Errorf("close outgoing connection: %w", err)
+	}
+	return nil
+}
+
+// transportWriter adapts a transport to the writer of the encrypter, which writes each
+// frame in a single call.
+type transportWriter struct {
+	transport Transport
+}
+
+func (w transportWriter) Write(frame []byte) (int, error) {
+	if err := w.transport.Send(frame); err != nil {
+		return 0, err
+	}
+	return len(frame), nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/posteo/fader/crypt"
+)
+
+// NewUnicast creates a Fader instance like NewMulticast, but for networks that don't route
+// multicast. Instead of joining a group, it listens on the given address and sends each
+// packet to every address of the peer list. The peer list can be changed at runtime
+// via AddPeer and RemovePeer.
+func NewUnicast(
+	parent Fader,
+	address string,
+	peers []string,
+	key []byte,
+	id []byte,
+	itemReceivedHandler ReceivedHandler,
+) (*Multicast, error) {
+	transport, err := NewUnicastTransport(address, peers)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := NewMulticastWithOptions(parent,
+		WithTransport(transport),
+		WithKey(key),
+		optionalID(id),
+		WithReceivedHandler(itemReceivedHandler))
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewUnicastWithKeyring creates a Fader instance like NewUnicast, but takes a keyring
+// instead of a single key.
+func NewUnicastWithKeyring(
+	parent Fader,
+	address string,
+	peers []string,
+	keyring *crypt.Keyring,
+	id []byte,
+	itemReceivedHandler ReceivedHandler,
+) (*Multicast, error) {
+	transport, err := NewUnicastTransport(address, peers)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := NewMulticastWithOptions(parent,
+		WithTransport(transport),
+		WithKeyring(keyring),
+		optionalID(id),
+		WithReceivedHandler(itemReceivedHandler))
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewUnicastTransport creates a transport, that listens on the given udp address and sends
+// each frame to every address of the peer list.
+func NewUnicastTransport(address string, peers []string) (Transport, error) {
+	udpAddress, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("resolve udp address [%s]: %w", address, err)
+	}
+
+	incomingConnection, err := net.ListenUDP("udp", udpAddress)
+	if err != nil {
+		return nil, fmt.Errorf("listen udp: %w", err)
+	}
+
+	outgoingConnection, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		incomingConnection.Close()
+		return nil, fmt.Errorf("listen udp: %w", err)
+	}
+
+	t := &unicastTransport{
+		incomingConnection: incomingConnection,
+		outgoingConnection: outgoingConnection,
+		peers:              make(map[string]*net.UDPAddr),
+	}
+	for _, peer := range peers {
+		if err := t.add(peer); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+type unicastTransport struct {
+	incomingConnection *net.UDPConn
+	outgoingConnection *net.UDPConn
+	mutex              sync.RWMutex
+	peers              map[string]*net.UDPAddr
+}
+
+func (t *unicastTransport) add(address string) error {
+	udpAddress, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return fmt.Errorf("resolve udp address [%s]: %w", address, err)
+	}
+
+	t.mutex.Lock()
+	t.peers[address] = udpAddress
+	t.mutex.Unlock()
+	return nil
+}
+
+func (t *unicastTransport) remove(address string) error {
+	t.mutex.Lock()
+	delete(t.peers, address)
+	t.mutex.Unlock()
+	return nil
+}
+
+func (t *unicastTransport) list() []string {
+	t.mutex.RLock()
+	addresses := make([]string, 0, len(t.peers))
+	for address := range t.peers {
+		addresses = append(addresses, address)
+	}
+	t.mutex.RUnlock()
+
+	sort.Strings(addresses)
+	return addresses
+}
+
+// Send sends the frame to all peers, even if sending to some of them fails. The errors
+// of all failed peers are returned together.
+func (t *unicastTransport) Send(frame []byte) error {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	errs := []error{}
+	for address, udpAddress := range t.peers {
+		if _, err := t.outgoingConnection.WriteToUDP(frame, udpAddress); err != nil {
+			errs = append(errs, fmt.Errorf("write to [%s]: %w", address, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t *unicastTransport) Receive(buffer []byte) (int, net.Addr, error) {
+	return t.incomingConnection.ReadFromUDP(buffer)
+}
+
+func (t *unicastTransport) Close() error {
+	if err := t.incomingConnection.Close(); err != nil {
+		return fmt.Errorf("close incoming connection: %w", err)
+	}
+	if err := t.outgoingConnection.Close(); err != nil {
+		return fmt.Errorf("close outgoing connection: %w", err)
+	}
+	return nil
+}
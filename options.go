@@ -0,0 +1,87 @@
+// Copyright 2014 The fader authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fader
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrInvalidOption is returned by a constructor if an option has an invalid value or
+// doesn't fit to the other options.
+var ErrInvalidOption = errors.New("invalid option")
+
+// Option configures both, a memory and a multicast fader.
+type Option interface {
+	MemoryOption
+	MulticastOption
+}
+
+type option struct {
+	memory    func(*Memory) error
+	multicast func(*multicastOptions) error
+}
+
+func (o option) applyMemory(m *Memory) error {
+	return o.memory(m)
+}
+
+func (o option) applyMulticast(options *multicastOptions) error {
+	return o.multicast(options)
+}
+
+// WithLogger sets the logger for errors, that can't be returned to the caller. The default
+// is the standard logger.
+func WithLogger(logger *log.Logger) Option {
+	return option{
+		memory: func(m *Memory) error {
+			if logger == nil {
+				return fmt.Errorf("nil logger: %w", ErrInvalidOption)
+			}
+			m.logger = logger
+			return nil
+		},
+		multicast: func(options *multicastOptions) error {
+			if logger == nil {
+				return fmt.Errorf("nil logger: %w", ErrInvalidOption)
+			}
+			options.logger = logger
+			return nil
+		},
+	}
+}
+
+// WithClock sets the function, that returns the current time. It's meant to control the
+// time in tests. The default is time.Now.
+func WithClock(now func() time.Time) Option {
+	return option{
+		memory: func(m *Memory) error {
+			if now == nil {
+				return fmt.Errorf("nil clock: %w", ErrInvalidOption)
+			}
+			m.now = now
+			return nil
+		},
+		multicast: func(options *multicastOptions) error {
+			if now == nil {
+				return fmt.Errorf("nil clock: %w", ErrInvalidOption)
+			}
+			options.now = now
+			return nil
+		},
+	}
+}